@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"PasswordManager/vault"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const auditFileName string = "audit.log"
+
+// Actions recorded in the audit log
+const (
+	ActionReveal               string = "reveal"
+	ActionCopy                 string = "copy"
+	ActionOTPCode              string = "otp-code"
+	ActionExport               string = "export"
+	ActionReprompt             string = "reprompt"
+	ActionChangeMasterPassword string = "change-master-password"
+	ActionRecoverySignIn       string = "recovery-sign-in"
+	ActionCreateRecoveryShares string = "create-recovery-shares"
+	ActionEnableTwoFactor      string = "enable-two-factor"
+	ActionDisableTwoFactor     string = "disable-two-factor"
+	ActionNewBackupCodes       string = "new-backup-codes"
+)
+
+// A single line of the audit log. Never holds secret values, only what was accessed.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	Action   string    `json:"action"`
+	ItemID   string    `json:"itemId,omitempty"`
+	Field    string    `json:"field,omitempty"`
+	Success  bool      `json:"success"`
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// Appends an entry to the audit log in the app directory as one JSON object per line
+func Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Could not write audit entry. %w", err)
+	}
+
+	appDir, err := vault.GetAppConfigDir()
+	if err != nil {
+		return fmt.Errorf("Could not write audit entry. %w", err)
+	}
+	file, err := os.OpenFile(path.Join(appDir, auditFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not open audit log. %w", err)
+	}
+	defer file.Close()
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Could not write audit entry. %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package breach
+
+import (
+	"PasswordManager/vault"
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const datasetFileName string = "pwned-passwords.bin"
+
+// Layout of the dataset file: a header of the magic and the number of entries, then the
+// entries sorted by hash. An entry is the first HASH_PREFIX_LEN bytes of the SHA-1 of a
+// password and how often it was seen, big endian.
+const (
+	MAGIC           string = "PWNDSHA1"
+	HEADER_LEN      int    = 16
+	HASH_PREFIX_LEN int    = 8
+	ENTRY_LEN       int    = HASH_PREFIX_LEN + 4
+)
+
+// Hex digits of a HIBP range file name and of the hash suffixes inside it
+const (
+	rangePrefixLen int = 5
+	hashHexLen     int = 2 * sha1.Size
+)
+
+var ErrNoDataset = errors.New("No breached password dataset has been imported")
+
+// The imported dataset, opened for lookups
+type Dataset struct {
+	file    *os.File
+	entries int64
+}
+
+// Builds the dataset in the app directory from a Have I Been Pwned SHA-1 download: either a
+// directory of range files, named by the first 5 hex digits of the hash and holding
+// "SUFFIX:COUNT" lines, or one file of "HASH:COUNT" lines ordered by hash. Hashes are only
+// checked to be in order, never sorted, so the import streams whatever its size. Returns the
+// number of hashes kept.
+func Import(source string) (int64, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return 0, fmt.Errorf("Could not read the breach data. %w", err)
+	}
+	appDir, err := vault.GetAppConfigDir()
+	if err != nil {
+		return 0, fmt.Errorf("Could not import the breach data. %w", err)
+	}
+	target := path.Join(appDir, datasetFileName)
+
+	file, err := os.OpenFile(target+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("Could not create the breach dataset. %w", err)
+	}
+	defer os.Remove(target + ".tmp")
+	defer file.Close()
+
+	writer := &datasetWriter{out: bufio.NewWriterSize(file, 1<<20)}
+	if _, err := writer.out.Write(make([]byte, HEADER_LEN)); err != nil {
+		return 0, fmt.Errorf("Could not write the breach dataset. %w", err)
+	}
+	if info.IsDir() {
+		err = importRanges(source, writer)
+	} else {
+		err = importFile(source, "", writer)
+	}
+	if err == nil {
+		err = writer.finish()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	//The header goes in last, once the number of entries is known
+	header := make([]byte, HEADER_LEN)
+	copy(header, MAGIC)
+	binary.BigEndian.PutUint64(header[len(MAGIC):], uint64(writer.entries))
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return 0, fmt.Errorf("Could not write the breach dataset. %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return 0, fmt.Errorf("Could not write the breach dataset. %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return 0, fmt.Errorf("Could not write the breach dataset. %w", err)
+	}
+	if err := os.Rename(target+".tmp", target); err != nil {
+		return 0, fmt.Errorf("Could not replace the breach dataset. %w", err)
+	}
+	return writer.entries, nil
+}
+
+func importRanges(dir string, writer *datasetWriter) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Could not read the breach data. %w", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		prefix := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if entry.Type().IsRegular() && isHex(prefix, rangePrefixLen) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("No range files found in %q", dir)
+	}
+	//Range files are named by hash prefix, so name order is hash order
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToUpper(names[i]) < strings.ToUpper(names[j])
+	})
+	for _, name := range names {
+		prefix := strings.TrimSuffix(name, filepath.Ext(name))
+		if err := importFile(filepath.Join(dir, name), prefix, writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads "HASH:COUNT" lines, with the first hex digits of every hash given as prefix for range files
+func importFile(name string, prefix string, writer *datasetWriter) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("Could not read the breach data. %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		hash, countText, found := strings.Cut(line, ":")
+		hash = prefix + hash
+		if !found || !isHex(hash, hashHexLen) {
+			return fmt.Errorf("%s:%d is not a SHA-1 hash and count", name, lineNumber)
+		}
+		count, err := strconv.ParseUint(countText, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d has a bad count. %w", name, lineNumber, err)
+		}
+		//Downloads with padding add fake hashes that were never seen
+		if count == 0 {
+			continue
+		}
+		digest, _ := hex.DecodeString(hash)
+		if err := writer.add(digest, count); err != nil {
+			return fmt.Errorf("%s:%d %w", name, lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Could not read the breach data. %w", err)
+	}
+	return nil
+}
+
+// Writes entries in hash order, merging hashes that share their kept prefix
+type datasetWriter struct {
+	out        *bufio.Writer
+	entries    int64
+	last       []byte
+	lastDigest []byte
+	count      uint64
+}
+
+func (writer *datasetWriter) add(digest []byte, count uint64) error {
+	prefix := digest[:HASH_PREFIX_LEN]
+	if writer.lastDigest != nil && bytes.Compare(digest, writer.lastDigest) <= 0 {
+		return errors.New("is out of order, the breach data must be ordered by hash")
+	}
+	writer.lastDigest = digest
+	if writer.last != nil && bytes.Equal(prefix, writer.last) {
+		writer.count += count
+		return nil
+	}
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	writer.last, writer.count = prefix, count
+	return nil
+}
+
+func (writer *datasetWriter) flush() error {
+	if writer.last == nil {
+		return nil
+	}
+	entry := make([]byte, ENTRY_LEN)
+	copy(entry, writer.last)
+	binary.BigEndian.PutUint32(entry[HASH_PREFIX_LEN:], uint32(min(writer.count, math.MaxUint32)))
+	if _, err := writer.out.Write(entry); err != nil {
+		return fmt.Errorf("Could not write the breach dataset. %w", err)
+	}
+	writer.entries++
+	return nil
+}
+
+func (writer *datasetWriter) finish() error {
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	if err := writer.out.Flush(); err != nil {
+		return fmt.Errorf("Could not write the breach dataset. %w", err)
+	}
+	return nil
+}
+
+// Opens the imported dataset. Returns ErrNoDataset when nothing was imported yet.
+func Open() (*Dataset, error) {
+	appDir, err := vault.GetAppConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("Could not open the breach dataset. %w", err)
+	}
+	file, err := os.Open(path.Join(appDir, datasetFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoDataset
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not open the breach dataset. %w", err)
+	}
+
+	header := make([]byte, HEADER_LEN)
+	info, err := file.Stat()
+	if err == nil {
+		_, err = io.ReadFull(file, header)
+	}
+	entries := int64(binary.BigEndian.Uint64(header[len(MAGIC):]))
+	if err != nil || string(header[:len(MAGIC)]) != MAGIC || info.Size() != int64(HEADER_LEN)+entries*int64(ENTRY_LEN) {
+		file.Close()
+		return nil, errors.New("The breach dataset is damaged, import it again")
+	}
+	return &Dataset{file: file, entries: entries}, nil
+}
+
+// Number of times password was seen in breaches, 0 if never. Only a prefix of each hash is
+// kept, so a password may rarely match a different breached one; with the full HIBP set
+// that happens for about one password in twenty billion.
+func (dataset *Dataset) Count(password string) (int, error) {
+	digest := sha1.Sum([]byte(password))
+	prefix := digest[:HASH_PREFIX_LEN]
+	entry := make([]byte, ENTRY_LEN)
+
+	var readErr error
+	index := sort.Search(int(dataset.entries), func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+		if _, err := dataset.file.ReadAt(entry, int64(HEADER_LEN)+int64(i)*int64(ENTRY_LEN)); err != nil {
+			readErr = err
+			return true
+		}
+		return bytes.Compare(entry[:HASH_PREFIX_LEN], prefix) >= 0
+	})
+	if readErr != nil {
+		return 0, fmt.Errorf("Could not read the breach dataset. %w", readErr)
+	}
+	if index == int(dataset.entries) {
+		return 0, nil
+	}
+	if _, err := dataset.file.ReadAt(entry, int64(HEADER_LEN)+int64(index)*int64(ENTRY_LEN)); err != nil {
+		return 0, fmt.Errorf("Could not read the breach dataset. %w", err)
+	}
+	if !bytes.Equal(entry[:HASH_PREFIX_LEN], prefix) {
+		return 0, nil
+	}
+	return int(binary.BigEndian.Uint32(entry[HASH_PREFIX_LEN:])), nil
+}
+
+func (dataset *Dataset) Close() error {
+	return dataset.file.Close()
+}
+
+func isHex(text string, length int) bool {
+	if len(text) != length {
+		return false
+	}
+	for _, char := range text {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", char) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package breach
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	digest := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(digest[:]))
+}
+
+// Writes passwords with their counts as HIBP range files, plus one padding line per file
+func writeRanges(t *testing.T, counts map[string]int) string {
+	dir := t.TempDir()
+	ranges := map[string][]string{}
+	for password, count := range counts {
+		hash := sha1Hex(password)
+		ranges[hash[:5]] = append(ranges[hash[:5]], hash[5:]+":"+strconv.Itoa(count))
+	}
+	for prefix, lines := range ranges {
+		sort.Strings(lines)
+		lines = append(lines, strings.Repeat("F", 35)+":0")
+		if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(strings.Join(lines, "\r\n")+"\r\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBreachDataset(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	if _, err := Open(); !errors.Is(err, ErrNoDataset) {
+		t.Fatalf("Expected ErrNoDataset before any import, got %v", err)
+	}
+
+	counts := map[string]int{"password": 9545824, "123456": 37359195, "letmein": 470, "hunter2": 32}
+	t.Run("Range files", func(t *testing.T) {
+		imported, err := Import(writeRanges(t, counts))
+		if err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		if imported != int64(len(counts)) {
+			t.Errorf("Expected %d hashes imported, got %d", len(counts), imported)
+		}
+
+		dataset, err := Open()
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer dataset.Close()
+		for password, want := range counts {
+			if got, err := dataset.Count(password); err != nil || got != want {
+				t.Errorf("%q: expected %d, got %d, %v", password, want, got, err)
+			}
+		}
+		for _, password := range []string{"Brisk-Lantern-Fjord-87", "", "passwordX"} {
+			if got, err := dataset.Count(password); err != nil || got != 0 {
+				t.Errorf("%q should not be breached, got %d, %v", password, got, err)
+			}
+		}
+	})
+
+	t.Run("Ordered file", func(t *testing.T) {
+		lines := []string{}
+		for password, count := range counts {
+			lines = append(lines, strings.ToLower(sha1Hex(password))+":"+strconv.Itoa(count))
+		}
+		sort.Strings(lines)
+		source := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+		os.WriteFile(source, []byte(strings.Join(lines, "\n")), 0600)
+		if _, err := Import(source); err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+		dataset, err := Open()
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer dataset.Close()
+		if got, _ := dataset.Count("letmein"); got != 470 {
+			t.Errorf("Expected 470, got %d", got)
+		}
+
+		//Out of order data is refused and leaves the dataset alone
+		lines[0], lines[1] = lines[1], lines[0]
+		os.WriteFile(source, []byte(strings.Join(lines, "\n")), 0600)
+		if _, err := Import(source); err == nil || !strings.Contains(err.Error(), "out of order") {
+			t.Errorf("Expected an ordering error, got %v", err)
+		}
+		if got, _ := dataset.Count("letmein"); got != 470 {
+			t.Errorf("A failed import should keep the old dataset, got %d", got)
+		}
+	})
+
+	t.Run("Damaged dataset", func(t *testing.T) {
+		appDir := filepath.Join(os.Getenv("AppData"), "Pharoas")
+		os.WriteFile(filepath.Join(appDir, datasetFileName), []byte(MAGIC+"short"), 0600)
+		if _, err := Open(); err == nil || errors.Is(err, ErrNoDataset) {
+			t.Errorf("Expected a damaged dataset error, got %v", err)
+		}
+	})
+}
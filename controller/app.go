@@ -1,94 +1,696 @@
 package controller
 
 import (
+	"PasswordManager/audit"
 	"PasswordManager/crypto"
+	"PasswordManager/otp"
+	"PasswordManager/strength"
 	"PasswordManager/user"
 	"PasswordManager/vault"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
 )
 
+// State of the signed in session. Every exported method holds mu, so concurrent requests never
+// see keys that another one is wiping.
 type App struct {
-	CurrentUser    *user.User
+	mu sync.Mutex
+
+	CurrentUser *user.User
+	//Ordinary heap strings that cannot be wiped. Only the JSON buffer they are parsed from and
+	//sealed into lives in a SecretBuffer.
 	DecryptedVault []vault.Credential
 	IsVaultLoaded  bool
-	key            []byte
+	//Random vault key that seals the credentials. Same buffer as masterKey for legacy vaults.
+	key *crypto.SecretBuffer
+	//Key derived from the master password, wraps the vault key in the password key slot
+	masterKey *crypto.SecretBuffer
+	//Digest of the keyfile given at sign-in, nil when the account does not use one
+	keyfileKey  *crypto.SecretBuffer
+	vaultHeader *vault.Header
+
+	//KDF parameters for new accounts. Accounts on weaker settings are upgraded at sign-in.
+	KDFPolicy crypto.KDFParams
+
+	//Least strength score a new master password needs, 0 to accept any
+	MinMasterPasswordScore int
+
+	//When set, revealing any secret needs a master password re-prompt within RepromptWindow
+	RequireRepromptForReveal bool
+	RepromptWindow           time.Duration
+	lastReprompt             time.Time
+
+	//Authenticator being enrolled for two-factor sign-in, not stored until confirmed
+	pendingTOTP *otp.Key
+}
+
+// Choices made when creating an account
+type SignUpOptions struct {
+	//Also wrap the vault key under a recovery key that is shown once
+	CreateRecoveryKey bool
+	//Require this keyfile next to the master password
+	Keyfile []byte
+	//Require a newly generated keyfile next to the master password, ignored when Keyfile is set
+	GenerateKeyfile bool
+}
+
+// Extra outcomes of a successful SignUp
+type SignUpResult struct {
+	//Printable recovery key. Never stored, so it has to be shown to the user right away.
+	RecoveryKey string
+	//Contents of the generated keyfile, to be saved by the user
+	Keyfile []byte
+}
+
+var (
+	ErrWrongPassword   = errors.New("wrong username or master password")
+	ErrVaultCorrupt    = errors.New("vault is corrupt")
+	ErrVaultMissing    = errors.New("vault is missing")
+	ErrKeyfileRequired = errors.New("a keyfile is required to unlock this vault")
+	ErrWeakPassword    = errors.New("master password is too easy to guess")
+)
+
+// Extra outcomes of a successful SignIn
+type SignInResult struct {
+	//The account was moved to the current KDF policy
+	KDFUpgraded bool
 }
 
+// New master passwords must take at least ten billion guesses
+const defaultMinMasterPasswordScore int = strength.SCORE_VERY_UNGUESSABLE - 1
+
 func NewApp() *App {
-	return &App{}
+	return &App{RepromptWindow: defaultRepromptWindow, KDFPolicy: crypto.DefaultKDFParams(), MinMasterPasswordScore: defaultMinMasterPasswordScore}
+}
+
+// Rejects a new master password scoring below MinMasterPasswordScore, with the estimator's
+// warning as the reason. userInputs are the account's username and the like.
+func (app *App) checkPasswordStrength(password string, userInputs ...string) error {
+	result := strength.Estimate(password, userInputs...)
+	if result.Score >= app.MinMasterPasswordScore {
+		return nil
+	}
+	if result.Feedback.Warning != "" {
+		return fmt.Errorf("%w: %s", ErrWeakPassword, result.Feedback.Warning)
+	}
+	return ErrWeakPassword
+}
+
+func (app *App) kdfPolicy() crypto.KDFParams {
+	if app.KDFPolicy.Algorithm == "" {
+		return crypto.DefaultKDFParams()
+	}
+	return app.KDFPolicy
 }
 
-func (app *App) SignUp(username string, password string) error {
+func (app *App) SignUp(username string, password string, opts SignUpOptions) (SignUpResult, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	var result SignUpResult
 	//Check if user Exists
 	recievedUser, err := user.GetUser(username)
 
 	if err != nil {
-		return fmt.Errorf("something went wrong %w", err)
+		return result, fmt.Errorf("something went wrong %w", err)
 	}
 	if recievedUser != nil {
-		return fmt.Errorf("User %q already exist", username)
+		return result, fmt.Errorf("User %q already exist", username)
+	}
+	if err := app.checkPasswordStrength(password, username); err != nil {
+		return result, err
 	}
 	//Generate a new Salt
 	salt, err := crypto.GenerateSalt()
 	if err != nil {
-		return fmt.Errorf("Something went wrong. Could not create user. %w", err)
+		return result, fmt.Errorf("Something went wrong. Could not create user. %w", err)
 	}
-	//Save the User to user_data.json
 	newUser := user.User{
 		Username:   username,
 		MasterSalt: salt,
+		KDF:        app.kdfPolicy(),
 	}
+
+	keyfile := opts.Keyfile
+	if len(keyfile) == 0 && opts.GenerateKeyfile {
+		if keyfile, err = crypto.GenerateKeyfile(); err != nil {
+			return result, fmt.Errorf("Something went wrong. Could not create user. %w", err)
+		}
+		result.Keyfile = keyfile
+	}
+	var keyfileKey *crypto.SecretBuffer
+	if len(keyfile) > 0 {
+		if keyfileKey, err = newKeyfileKey(keyfile); err != nil {
+			return SignUpResult{}, fmt.Errorf("Something went wrong. Could not create user. %w", err)
+		}
+		defer keyfileKey.Destroy()
+		newUser.RequiresKeyfile = true
+	}
+
+	//Key pair for items shared with this user, the private key is sealed into the vault below
+	publicKey, privateKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return SignUpResult{}, fmt.Errorf("Something went wrong. Could not create user. %w", err)
+	}
+	defer crypto.Wipe(privateKey)
+	newUser.PublicKey = publicKey
+
+	MEK, err := deriveMasterKey(password, newUser.MasterSalt, newUser.KeyDerivation(), keyfileKey)
+	if err != nil {
+		return SignUpResult{}, fmt.Errorf("Something went wrong. Could not create user. %w", err)
+	}
+	defer MEK.Destroy()
+	newUser.KeyCheck, err = crypto.KeyCheckValue(MEK.Bytes())
+	if err != nil {
+		return result, fmt.Errorf("Something went wrong. Could not create user. %w", err)
+	}
+
+	//Save the User to user_data.json
 	err = user.SaveUser(&newUser)
 
 	if err != nil {
-		return fmt.Errorf("Something went wrong. Could not create user. %w", err)
+		return result, fmt.Errorf("Something went wrong. Could not create user. %w", err)
+	}
+
+	//The credentials are sealed under a random vault key which the MEK only wraps
+	vaultKey, err := generateVaultKey()
+	if err != nil {
+		return result, fmt.Errorf("Something went wrong. Could not create user. %w", err)
+	}
+	defer vaultKey.Destroy()
+	header := vault.NewHeader()
+	if err = header.SetKeySlot(vault.SlotPassword, vaultKey.Bytes(), MEK.Bytes()); err != nil {
+		return result, fmt.Errorf("Encryption Failed. %w", err)
+	}
+	if err = header.SetPrivateKey(privateKey, vaultKey.Bytes()); err != nil {
+		return result, fmt.Errorf("Encryption Failed. %w", err)
 	}
 
-	MEK := crypto.GetDerivedKey([]byte(password), newUser.MasterSalt, 100096)
+	if opts.CreateRecoveryKey {
+		recoveryKey, formatted, err := crypto.GenerateRecoveryKey()
+		if err != nil {
+			return result, fmt.Errorf("Something went wrong. Could not create user. %w", err)
+		}
+		err = header.SetKeySlot(vault.SlotRecovery, vaultKey.Bytes(), recoveryKey)
+		crypto.Wipe(recoveryKey)
+		if err != nil {
+			return result, fmt.Errorf("Encryption Failed. %w", err)
+		}
+		result.RecoveryKey = formatted
+	}
 
-	err = vault.EncryptAndSaveVault([]vault.Credential{}, MEK)
+	err = vault.EncryptAndSaveVault(username, []vault.Credential{}, header, vaultKey.Bytes())
 
 	if err != nil {
-		return fmt.Errorf("Encryption Failed. %w", err)
+		return SignUpResult{}, fmt.Errorf("Encryption Failed. %w", err)
 	}
 
-	return nil
+	return result, nil
+}
+
+// Unlocks the vault of username. Failures are reported as ErrWrongPassword, ErrVaultMissing or
+// ErrVaultCorrupt where they can be told apart, and never leave a user signed in. Accounts with
+// two-factor sign-in fail with ErrTwoFactorRequired, see SignInWithCode.
+func (app *App) SignIn(username string, password string) (SignInResult, error) {
+	return app.SignInWithCode(username, password, nil, "")
+}
+
+// Like SignIn for accounts that also need a keyfile. Without one such accounts fail with
+// ErrKeyfileRequired, and a wrong keyfile looks like a wrong password. The keyfile is ignored
+// for accounts that do not use one.
+func (app *App) SignInWithKeyfile(username string, password string, keyfile []byte) (SignInResult, error) {
+	return app.SignInWithCode(username, password, keyfile, "")
 }
 
-func (app *App) SignIn(username string, password string) error {
+// Like SignInWithKeyfile with a TOTP or backup code for accounts with two-factor sign-in. The
+// code is checked once the password is known to be right and before the vault is unlocked, so a
+// wrong password never uses it up; a wrong or reused code fails with ErrWrongCode. The code is
+// ignored for other accounts.
+func (app *App) SignInWithCode(username string, password string, keyfile []byte, code string) (SignInResult, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	result, err := app.signIn(username, password, keyfile, code)
+	if err != nil {
+		app.signOut()
+	}
+	return result, err
+}
+
+func (app *App) signIn(username string, password string, keyfile []byte, code string) (SignInResult, error) {
 	app.IsVaultLoaded = false
+	var result SignInResult
 	var err error
 
-	//Get user
+	//Finish a user/vault update that was interrupted
+	if err = vault.RecoverPendingCommit(); err != nil {
+		return result, fmt.Errorf("Could not recover an interrupted update. %w", err)
+	}
+
+	//Get user. An unknown user is reported like a wrong password so accounts cannot be probed.
 	app.CurrentUser, err = user.GetUser(username)
 	if err != nil {
-		return fmt.Errorf("User %q does not Exist.: %v", username, err.Error())
+		return result, fmt.Errorf("User %q does not Exist.: %v", username, err.Error())
+	}
+	if app.CurrentUser == nil {
+		return result, ErrWrongPassword
+	}
+
+	if app.CurrentUser.RequiresKeyfile {
+		if len(keyfile) == 0 {
+			return result, ErrKeyfileRequired
+		}
+		if app.keyfileKey, err = newKeyfileKey(keyfile); err != nil {
+			return result, err
+		}
+	}
+
+	//Deruve Key from user Salt, input password and keyfile
+	app.masterKey, err = deriveMasterKey(password, app.CurrentUser.MasterSalt, app.CurrentUser.KeyDerivation(), app.keyfileKey)
+	if err != nil {
+		return result, fmt.Errorf("Could not derive key for %q. %w", username, err)
 	}
 
-	//Deruve Key from user Salt and input password
-	app.key = crypto.GetDerivedKey([]byte(password), app.CurrentUser.MasterSalt, 100096)
+	//With a key-check value the password is verified before the vault is touched, so any
+	//decryption failure after this point means the vault itself is damaged
+	passwordVerified := false
+	if len(app.CurrentUser.KeyCheck) > 0 {
+		ok, err := crypto.VerifyKeyCheck(app.masterKey.Bytes(), app.CurrentUser.KeyCheck)
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			return result, ErrWrongPassword
+		}
+		passwordVerified = true
+	}
+
+	//The code is checked before the vault is touched, but only once the password is proven, so
+	//a wrong password never uses one up. Enrolling stores a key-check value, so accounts without
+	//one are checked once their vault has opened.
+	twoFactorChecked := false
+	if passwordVerified && app.CurrentUser.HasTwoFactor() {
+		if err := app.checkSecondFactor(code, time.Now()); err != nil {
+			return result, err
+		}
+		twoFactorChecked = true
+	}
+	decryptionFailed := func(err error) error {
+		if passwordVerified || errors.Is(err, vault.ErrVaultCorrupt) {
+			return fmt.Errorf("%w: %v", ErrVaultCorrupt, err)
+		}
+		//Without a key-check value a wrong password is by far the likelier cause
+		return fmt.Errorf("%w: %v", ErrWrongPassword, err)
+	}
+
+	//Unwrap the vault key, legacy vaults are sealed directly under the master key
+	header, sealed, err := vault.LoadVault(username)
+	if errors.Is(err, vault.ErrVaultMissing) {
+		return result, ErrVaultMissing
+	}
+	if err != nil {
+		return result, decryptionFailed(err)
+	}
+	app.vaultHeader = header
+	app.key = app.masterKey
+	if header != nil {
+		app.key, err = unwrapVaultKey(header, vault.SlotPassword, app.masterKey.Bytes())
+		if err != nil {
+			return result, decryptionFailed(err)
+		}
+	}
 
 	//Decrypt Vault
-	app.DecryptedVault, err = vault.LoadAndDecryptVault(app.key)
+	app.DecryptedVault, err = vault.OpenVault(header, sealed, app.key.Bytes())
 	if err != nil {
-		return fmt.Errorf("Decryption Failed. %w", err)
+		return result, decryptionFailed(err)
+	}
+
+	if !twoFactorChecked && app.CurrentUser.HasTwoFactor() {
+		if err := app.checkSecondFactor(code, time.Now()); err != nil {
+			return result, err
+		}
 	}
 
 	app.IsVaultLoaded = true
+
+	if err := vault.ClaimLegacyVault(username); err != nil {
+		log.Printf("Moving the shared vault to %q failed: %v", username, err)
+	}
+
+	//Items saved before items had IDs cannot be told apart otherwise
+	if err := app.assignMissingIDs(); err != nil {
+		log.Printf("Saving new item IDs for %q failed: %v", username, err)
+	}
+
+	if app.vaultHeader == nil {
+		if err := app.migrateToVaultKey(); err != nil {
+			log.Printf("Moving the vault of %q to a wrapped vault key failed: %v", username, err)
+		}
+	}
+
+	//The password is known to be right now, so this is the one chance to move the account to
+	//the current KDF policy. Failing to upgrade must not lock the user out.
+	if app.CurrentUser.KeyDerivation().WeakerThan(app.kdfPolicy()) {
+		if err := app.setMasterPassword(password); err != nil {
+			log.Printf("KDF upgrade for %q failed: %v", username, err)
+		} else {
+			result.KDFUpgraded = true
+		}
+	}
+
+	//Password slots from before the key-wrap subkey use the master key that also makes the
+	//key-check value, so they are wrapped again under the subkey
+	if app.vaultHeader != nil && app.vaultHeader.HasLegacyKeySlot(vault.SlotPassword) {
+		if err := app.rewrapPasswordSlot(); err != nil {
+			log.Printf("Re-wrapping the vault key of %q failed: %v", username, err)
+		}
+	}
+
+	//Accounts from before key-check values get one now
+	if len(app.CurrentUser.KeyCheck) == 0 {
+		if err := app.storeKeyCheck(); err != nil {
+			log.Printf("Storing a key-check value for %q failed: %v", username, err)
+		}
+	}
+
+	//Likewise for key pairs
+	if app.vaultHeader != nil && (len(app.CurrentUser.PublicKey) == 0 || app.vaultHeader.PrivateKey == nil) {
+		if err := app.createKeyPair(); err != nil {
+			log.Printf("Creating a key pair for %q failed: %v", username, err)
+		}
+	}
+	return result, nil
+}
+
+// Gives every item without an ID a new one and saves the vault. The IDs hold for the session
+// even when saving fails.
+func (app *App) assignMissingIDs() error {
+	assigned := false
+	for i := range app.DecryptedVault {
+		if app.DecryptedVault[i].ID != "" {
+			continue
+		}
+		id, err := vault.NewCredentialID()
+		if err != nil {
+			return err
+		}
+		app.DecryptedVault[i].ID = id
+		assigned = true
+	}
+	if !assigned {
+		return nil
+	}
+	return vault.EncryptAndSaveVault(app.CurrentUser.Username, app.DecryptedVault, app.vaultHeader, app.key.Bytes())
+}
+
+// Wraps the vault key again under the current master key and saves the vault
+func (app *App) rewrapPasswordSlot() error {
+	header := app.vaultHeader.Clone()
+	if err := header.SetKeySlot(vault.SlotPassword, app.key.Bytes(), app.masterKey.Bytes()); err != nil {
+		return err
+	}
+	return app.commitUserAndVault(nil, header, app.key)
+}
+
+// Gives the signed in user a new X25519 key pair, sealing the private key into the vault
+func (app *App) createKeyPair() error {
+	publicKey, privateKey, err := crypto.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(privateKey)
+	header := app.vaultHeader.Clone()
+	if err := header.SetPrivateKey(privateKey, app.key.Bytes()); err != nil {
+		return err
+	}
+	updated := *app.CurrentUser
+	updated.PublicKey = publicKey
+	return app.commitUserAndVault(&updated, header, app.key)
+}
+
+// Saves the key-check value of the current master key in the user record
+func (app *App) storeKeyCheck() error {
+	keyCheck, err := crypto.KeyCheckValue(app.masterKey.Bytes())
+	if err != nil {
+		return err
+	}
+	updated := *app.CurrentUser
+	updated.KeyCheck = keyCheck
+	return app.saveUser(&updated)
+}
+
+// Replaces the master password. The old password is verified first, then the vault key is
+// wrapped under a key derived from the new password with a fresh salt and the current KDF
+// policy, and the vault and user record are committed together.
+func (app *App) ChangeMasterPassword(oldPassword string, newPassword string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	err := app.changeMasterPassword(oldPassword, newPassword)
+	if auditErr := app.recordAudit(audit.Entry{Action: audit.ActionChangeMasterPassword}, err); auditErr != nil {
+		return auditErr
+	}
+	return err
+}
+
+func (app *App) changeMasterPassword(oldPassword string, newPassword string) error {
+	if err := app.checkMasterPassword(oldPassword); err != nil {
+		return err
+	}
+	if newPassword == "" {
+		return fmt.Errorf("New master password cannot be empty")
+	}
+	if err := app.checkPasswordStrength(newPassword, app.CurrentUser.Username); err != nil {
+		return err
+	}
+
+	if err := app.setMasterPassword(newPassword); err != nil {
+		return fmt.Errorf("Could not change master password. %w", err)
+	}
+	//The old password no longer proves anything
+	app.lastReprompt = time.Time{}
+	return nil
+}
+
+// Re-seals a legacy vault under a fresh random vault key wrapped by the master key
+func (app *App) migrateToVaultKey() error {
+	vaultKey, err := generateVaultKey()
+	if err != nil {
+		return err
+	}
+	header := vault.NewHeader()
+	if err := header.SetKeySlot(vault.SlotPassword, vaultKey.Bytes(), app.masterKey.Bytes()); err != nil {
+		vaultKey.Destroy()
+		return err
+	}
+	if err := app.commitUserAndVault(nil, header, vaultKey); err != nil {
+		vaultKey.Destroy()
+		return err
+	}
+	return nil
+}
+
+// Derives a new master key from password and the session's keyfile, if any, with a fresh salt
+// and the current KDF policy, re-wraps the vault key with it (or re-encrypts a legacy vault) and
+// commits the vault and user record together
+func (app *App) setMasterPassword(password string) error {
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return err
+	}
+	updated := *app.CurrentUser
+	updated.MasterSalt = salt
+	updated.KDF = app.kdfPolicy()
+	updated.RequiresKeyfile = app.keyfileKey != nil
+
+	newMasterKey, err := deriveMasterKey(password, updated.MasterSalt, updated.KDF, app.keyfileKey)
+	if err != nil {
+		return err
+	}
+	updated.KeyCheck, err = crypto.KeyCheckValue(newMasterKey.Bytes())
+	if err == nil {
+		err = app.rewrapMasterKey(&updated, newMasterKey)
+	}
+	if err != nil {
+		newMasterKey.Destroy()
+		return err
+	}
+	return nil
+}
+
+// Puts the vault key under a new master key and commits it with the updated user record. On
+// success the app owns newMasterKey.
+func (app *App) rewrapMasterKey(updated *user.User, newMasterKey *crypto.SecretBuffer) error {
+	var header *vault.Header
+	key := newMasterKey
+	if app.vaultHeader != nil {
+		header = app.vaultHeader.Clone()
+		if err := header.SetKeySlot(vault.SlotPassword, app.key.Bytes(), newMasterKey.Bytes()); err != nil {
+			return err
+		}
+		key = app.key
+	}
+	if err := app.commitUserAndVault(updated, header, key); err != nil {
+		return err
+	}
+	app.replaceKeys(app.key, newMasterKey)
 	return nil
 }
 
+// Writes the user record and the vault sealed under key with header as one unit, so a crash
+// never leaves a user record that cannot open the vault. A nil user only writes the vault.
+// On success the app switches to the new header, key and user.
+func (app *App) commitUserAndVault(updated *user.User, header *vault.Header, key *crypto.SecretBuffer) error {
+	files := map[string][]byte{}
+	if updated != nil {
+		userPath, userData, err := user.PrepareUserUpdate(updated)
+		if err != nil {
+			return err
+		}
+		files[userPath] = userData
+	}
+	vaultPath, err := vault.GetVaultPath(app.CurrentUser.Username)
+	if err != nil {
+		return err
+	}
+	files[vaultPath], err = vault.SealVault(app.DecryptedVault, header, key.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := vault.CommitFiles(files); err != nil {
+		return err
+	}
+
+	if updated != nil {
+		app.CurrentUser = updated
+	}
+	app.vaultHeader = header
+	app.replaceKeys(key, app.masterKey)
+	return nil
+}
+
+// Switches to a new vault key and master key, wiping whichever old key buffer is not kept
+func (app *App) replaceKeys(key *crypto.SecretBuffer, masterKey *crypto.SecretBuffer) {
+	for _, old := range []*crypto.SecretBuffer{app.key, app.masterKey} {
+		if old != key && old != masterKey {
+			old.Destroy()
+		}
+	}
+	app.key = key
+	app.masterKey = masterKey
+}
+
+// Derives the master key from password straight into a SecretBuffer, combined with the keyfile
+// digest when there is one. The password bytes are wiped once used, but the Go string passed
+// in cannot be and is left to the garbage collector.
+func deriveMasterKey(password string, salt []byte, params crypto.KDFParams, keyfileKey *crypto.SecretBuffer) (*crypto.SecretBuffer, error) {
+	passwordBytes, err := crypto.NewSecretBufferFrom([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+	defer passwordBytes.Destroy()
+	key, err := crypto.DeriveKey(passwordBytes.Bytes(), salt, params)
+	if err != nil {
+		return nil, err
+	}
+	if keyfileKey != nil {
+		passwordKey := key
+		key, err = crypto.CombineKeyfile(passwordKey, keyfileKey.Bytes())
+		crypto.Wipe(passwordKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return crypto.NewSecretBufferFrom(key)
+}
+
+func newKeyfileKey(keyfile []byte) (*crypto.SecretBuffer, error) {
+	digest, err := crypto.KeyfileDigest(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewSecretBufferFrom(digest)
+}
+
+func generateVaultKey() (*crypto.SecretBuffer, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewSecretBufferFrom(key)
+}
+
+func unwrapVaultKey(header *vault.Header, kind string, wrappingKey []byte) (*crypto.SecretBuffer, error) {
+	key, err := header.UnwrapKey(kind, wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewSecretBufferFrom(key)
+}
+
+// Wipes the keys and forgets the decrypted vault. Also called on shutdown.
 func (app *App) SignOut() {
-	app.key = nil
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.signOut()
+}
+
+// Reports whether a user is signed in
+func (app *App) IsSignedIn() bool {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.CurrentUser != nil
+}
+
+// SignOut for callers already holding app.mu
+func (app *App) signOut() {
+	app.replaceKeys(nil, nil)
+	app.keyfileKey.Destroy()
+	app.keyfileKey = nil
+	app.vaultHeader = nil
+	app.lastReprompt = time.Time{}
+	app.pendingTOTP = nil
 	app.DecryptedVault = nil
 	app.CurrentUser = nil
 	app.IsVaultLoaded = false
 }
 
-func (app *App) AddCredential(url string, username string, password string) error {
+// An item that has to be rotated soon, as returned by GetExpiringCredentials
+type ExpiringCredential struct {
+	vault.RedactedCredential
+	Expired  bool `json:"expired"`
+	DaysLeft int  `json:"daysLeft"`
+}
 
-	app.DecryptedVault = append(app.DecryptedVault, vault.Credential{URL: url, Username: username, Password: password})
-	err := vault.EncryptAndSaveVault(app.DecryptedVault, app.key)
+func (app *App) AddCredential(cred vault.Credential) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if !app.IsVaultLoaded {
+		return ErrVaultLocked
+	}
+	var err error
+	cred.ID, err = vault.NewCredentialID()
+	if err != nil {
+		return fmt.Errorf("Could not add credentials. %w", err)
+	}
+	if cred.OTP != "" {
+		key, err := otp.ParseURI(cred.OTP)
+		if err != nil {
+			return fmt.Errorf("Could not add credentials. %w", err)
+		}
+		cred.OTP = key.URI()
+	}
+	if cred.PasswordChangedAt.IsZero() {
+		cred.PasswordChangedAt = time.Now().UTC()
+	}
+	if cred.RotateBy.IsZero() {
+		cred.ScheduleRotation(cred.PasswordChangedAt)
+	}
+
+	app.DecryptedVault = append(app.DecryptedVault, cred)
+	err = vault.EncryptAndSaveVault(app.CurrentUser.Username, app.DecryptedVault, app.vaultHeader, app.key.Bytes())
 	if err != nil {
 		app.DecryptedVault[len(app.DecryptedVault)-1] = vault.Credential{}
 		app.DecryptedVault = app.DecryptedVault[0 : len(app.DecryptedVault)-1]
@@ -97,9 +699,41 @@ func (app *App) AddCredential(url string, username string, password string) erro
 	return nil
 }
 
-func (app *App) GetCredentialsForDisplay() []vault.Credential {
+func (app *App) GetCredentialsForDisplay() []vault.RedactedCredential {
+	app.mu.Lock()
+	defer app.mu.Unlock()
 	if !app.IsVaultLoaded {
 		return nil
 	}
-	return app.DecryptedVault
+	redacted := make([]vault.RedactedCredential, 0, len(app.DecryptedVault))
+	for _, cred := range app.DecryptedVault {
+		redacted = append(redacted, cred.Redact())
+	}
+	return redacted
+}
+
+// Lists the items that are expired or have to be rotated within the given number of days,
+// soonest first
+func (app *App) GetExpiringCredentials(days int) []ExpiringCredential {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if !app.IsVaultLoaded {
+		return nil
+	}
+	now := time.Now()
+	expiring := []ExpiringCredential{}
+	for _, cred := range app.DecryptedVault {
+		if !cred.IsDueWithin(now, days) {
+			continue
+		}
+		expiring = append(expiring, ExpiringCredential{
+			RedactedCredential: cred.Redact(),
+			Expired:            cred.IsExpired(now),
+			DaysLeft:           cred.DaysLeft(now),
+		})
+	}
+	sort.Slice(expiring, func(i, j int) bool {
+		return expiring[i].RotateBy.Before(expiring[j].RotateBy)
+	})
+	return expiring
 }
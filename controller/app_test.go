@@ -0,0 +1,500 @@
+package controller
+
+import (
+	"PasswordManager/crypto"
+	"PasswordManager/user"
+	"PasswordManager/vault"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Cheap Argon2id settings so tests stay fast
+var testKDFPolicy = crypto.KDFParams{Algorithm: crypto.KDF_ARGON2ID, Memory: 64, Time: 1, Parallelism: 1}
+
+func TestSignInUpgradesLegacyKDF(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	//An account created before KDF parameters were stored
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.SaveUser(&user.User{Username: "legacy", MasterSalt: salt}); err != nil {
+		t.Fatal(err)
+	}
+	legacyKey := crypto.GetDerivedKey([]byte("hunter2"), salt, crypto.LEGACY_PBKDF2_ITERATIONS)
+	if err := vault.EncryptAndSaveVault("legacy", []vault.Credential{{ID: "1", Password: "secret"}}, nil, legacyKey); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	result, err := app.SignIn("legacy", "hunter2")
+	if err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	if !result.KDFUpgraded {
+		t.Fatal("SignIn should report the KDF upgrade")
+	}
+
+	stored, err := user.GetUser("legacy")
+	if err != nil || stored == nil {
+		t.Fatalf("Could not read upgraded user: %v", err)
+	}
+	if stored.KDF != testKDFPolicy {
+		t.Errorf("Stored KDF params not upgraded, got %+v", stored.KDF)
+	}
+	header, _, err := vault.LoadVault("legacy")
+	if err != nil || header == nil || !header.HasKeySlot(vault.SlotPassword) {
+		t.Fatalf("Legacy vault should have been moved to a wrapped vault key, got %+v, %v", header, err)
+	}
+	if len(stored.PublicKey) != crypto.BOX_KEY_LEN || header.PrivateKey == nil {
+		t.Error("Legacy accounts should get a key pair at sign in")
+	}
+
+	app.SignOut()
+	result, err = app.SignIn("legacy", "hunter2")
+	if err != nil {
+		t.Fatalf("SignIn after upgrade failed: %v", err)
+	}
+	if result.KDFUpgraded {
+		t.Error("An up to date account should not be upgraded again")
+	}
+	if len(app.DecryptedVault) != 1 || app.DecryptedVault[0].Password != "secret" {
+		t.Errorf("Vault contents changed by upgrade: %+v", app.DecryptedVault)
+	}
+
+	app.SignOut()
+	if _, err := app.SignIn("legacy", "wrong"); err == nil {
+		t.Error("SignIn with the wrong password should fail")
+	}
+}
+
+func TestChangeMasterPassword(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("alice", "Pale-Orbit-Kettle-42", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if _, err := app.SignIn("alice", "Pale-Orbit-Kettle-42"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	if err := app.AddCredential(vault.Credential{URL: "https://example.com", Password: "secret"}); err != nil {
+		t.Fatalf("AddCredential failed: %v", err)
+	}
+	oldSalt := app.CurrentUser.MasterSalt
+	oldMasterKey, vaultKey := app.masterKey, app.key
+
+	if err := app.ChangeMasterPassword("not it", "Brisk-Lantern-Fjord-87"); !errors.Is(err, ErrWrongReprompt) {
+		t.Fatalf("Wrong old password should be rejected, got %v", err)
+	}
+	if err := app.ChangeMasterPassword("Pale-Orbit-Kettle-42", "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Fatalf("ChangeMasterPassword failed: %v", err)
+	}
+	if bytes.Equal(app.CurrentUser.MasterSalt, oldSalt) {
+		t.Error("Changing the master password should generate a fresh salt")
+	}
+	if oldMasterKey.Bytes() != nil {
+		t.Error("The replaced master key should be wiped")
+	}
+
+	app.SignOut()
+	if vaultKey.Bytes() != nil || app.key != nil || app.masterKey != nil {
+		t.Error("SignOut should wipe the vault key and master key")
+	}
+	if _, err := app.SignIn("alice", "Pale-Orbit-Kettle-42"); err == nil {
+		t.Error("Old master password should no longer unlock the vault")
+	}
+	app.SignOut()
+	if _, err := app.SignIn("alice", "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Fatalf("New master password should unlock the vault: %v", err)
+	}
+	if len(app.DecryptedVault) != 1 || app.DecryptedVault[0].Password != "secret" {
+		t.Errorf("Vault contents changed by password change: %+v", app.DecryptedVault)
+	}
+}
+
+func TestSignInWithRecoveryKey(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	signup, err := app.SignUp("bob", "Mossy-Anchor-Quill-19", SignUpOptions{CreateRecoveryKey: true})
+	if err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if signup.RecoveryKey == "" {
+		t.Fatal("SignUp should return a recovery key when asked to")
+	}
+	if _, err := EmergencyKit("bob", signup.RecoveryKey); err != nil {
+		t.Errorf("EmergencyKit failed: %v", err)
+	}
+
+	_, wrongKey, _ := crypto.GenerateRecoveryKey()
+	if err := app.SignInWithRecoveryKey("bob", wrongKey, "Brisk-Lantern-Fjord-87"); err == nil {
+		t.Fatal("A different recovery key should not unlock the vault")
+	}
+	if err := app.SignInWithRecoveryKey("bob", strings.ToLower(signup.RecoveryKey), "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Fatalf("SignInWithRecoveryKey failed: %v", err)
+	}
+	if !app.IsVaultLoaded {
+		t.Error("Vault should be unlocked after recovery")
+	}
+
+	app.SignOut()
+	if _, err := app.SignIn("bob", "Mossy-Anchor-Quill-19"); err == nil {
+		t.Error("Recovery should replace the old master password")
+	}
+	app.SignOut()
+	if _, err := app.SignIn("bob", "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Errorf("New master password should unlock the vault: %v", err)
+	}
+}
+
+func TestSignInErrors(t *testing.T) {
+	appDir := t.TempDir()
+	t.Setenv("AppData", appDir)
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("carol", "Velvet-Comet-Harbor-63", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+
+	if _, err := app.SignIn("carol", "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("Wrong password should give ErrWrongPassword, got %v", err)
+	}
+	if app.CurrentUser != nil {
+		t.Error("A failed sign in should not leave a user signed in")
+	}
+	if _, err := app.SignIn("nobody", "Velvet-Comet-Harbor-63"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("Unknown user should give ErrWrongPassword, got %v", err)
+	}
+
+	vaultPath, err := vault.GetVaultPath("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(vaultPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Flip a bit in the sealed credentials at the end of the file
+	damaged := append([]byte{}, contents...)
+	damaged[len(damaged)-1] ^= 0x01
+	os.WriteFile(vaultPath, damaged, 0644)
+	if _, err := app.SignIn("carol", "Velvet-Comet-Harbor-63"); !errors.Is(err, ErrVaultCorrupt) {
+		t.Errorf("Damaged vault should give ErrVaultCorrupt, got %v", err)
+	}
+
+	os.Remove(vaultPath)
+	if _, err := app.SignIn("carol", "Velvet-Comet-Harbor-63"); !errors.Is(err, ErrVaultMissing) {
+		t.Errorf("Missing vault should give ErrVaultMissing, got %v", err)
+	}
+
+	os.WriteFile(vaultPath, contents, 0644)
+	if _, err := app.SignIn("carol", "Velvet-Comet-Harbor-63"); err != nil {
+		t.Errorf("Restored vault should open, got %v", err)
+	}
+}
+
+func TestSignInWithKeyfile(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	result, err := app.SignUp("dave", "Amber-Tundra-Piston-58", SignUpOptions{GenerateKeyfile: true})
+	if err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if len(result.Keyfile) == 0 {
+		t.Fatal("SignUp should return the generated keyfile")
+	}
+
+	if _, err := app.SignIn("dave", "Amber-Tundra-Piston-58"); !errors.Is(err, ErrKeyfileRequired) {
+		t.Errorf("Signing in without the keyfile should give ErrKeyfileRequired, got %v", err)
+	}
+	if _, err := app.SignInWithKeyfile("dave", "Amber-Tundra-Piston-58", []byte("not the keyfile")); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("A wrong keyfile should give ErrWrongPassword, got %v", err)
+	}
+	if _, err := app.SignInWithKeyfile("dave", "Amber-Tundra-Piston-58", result.Keyfile); err != nil {
+		t.Fatalf("SignInWithKeyfile failed: %v", err)
+	}
+	if err := app.VerifyMasterPassword("Amber-Tundra-Piston-58"); err != nil {
+		t.Errorf("Re-prompt should accept the password while the keyfile is loaded: %v", err)
+	}
+
+	//A new master password keeps the keyfile requirement
+	if err := app.ChangeMasterPassword("Amber-Tundra-Piston-58", "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Fatalf("ChangeMasterPassword failed: %v", err)
+	}
+	app.SignOut()
+	if _, err := app.SignIn("dave", "Brisk-Lantern-Fjord-87"); !errors.Is(err, ErrKeyfileRequired) {
+		t.Errorf("The keyfile should still be required, got %v", err)
+	}
+	if _, err := app.SignInWithKeyfile("dave", "Brisk-Lantern-Fjord-87", result.Keyfile); err != nil {
+		t.Errorf("SignInWithKeyfile after password change failed: %v", err)
+	}
+}
+
+func TestSignInWithRecoveryShares(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("erin", "Mossy-Anchor-Quill-19", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if _, err := app.SignIn("erin", "Mossy-Anchor-Quill-19"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	if err := app.AddCredential(vault.Credential{URL: "https://example.com", Password: "secret"}); err != nil {
+		t.Fatalf("AddCredential failed: %v", err)
+	}
+	if _, err := app.CreateRecoveryShares("wrong", 3, 2); !errors.Is(err, ErrWrongReprompt) {
+		t.Errorf("Creating shares should need the master password, got %v", err)
+	}
+	shares, err := app.CreateRecoveryShares("Mossy-Anchor-Quill-19", 3, 2)
+	if err != nil || len(shares) != 3 {
+		t.Fatalf("CreateRecoveryShares returned %d shares, %v", len(shares), err)
+	}
+	app.SignOut()
+
+	if err := app.SignInWithRecoveryShares("erin", shares[:1], "Brisk-Lantern-Fjord-87"); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("One share of two should give ErrNotEnoughShares, got %v", err)
+	}
+	if err := app.SignInWithRecoveryKey("erin", crypto.FormatRecoveryKey(make([]byte, crypto.KEY_LEN)), "Brisk-Lantern-Fjord-87"); !errors.Is(err, ErrNoRecoveryKey) {
+		t.Errorf("A vault without recovery key should give ErrNoRecoveryKey, got %v", err)
+	}
+	if err := app.SignInWithRecoveryShares("erin", []string{shares[2], shares[0]}, "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Fatalf("SignInWithRecoveryShares failed: %v", err)
+	}
+	if len(app.DecryptedVault) != 1 || app.DecryptedVault[0].Password != "secret" {
+		t.Errorf("Recovered vault has %+v", app.DecryptedVault)
+	}
+
+	app.SignOut()
+	if _, err := app.SignIn("erin", "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Errorf("The new master password should unlock the vault: %v", err)
+	}
+}
+
+func TestWeakMasterPassword(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	for _, password := range []string{"password1", "frank", "qwertyuiop", "Frank1990"} {
+		if _, err := app.SignUp("frank", password, SignUpOptions{}); !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("SignUp with %q should give ErrWeakPassword, got %v", password, err)
+		}
+	}
+	if stored, _ := user.GetUser("frank"); stored != nil {
+		t.Fatal("A rejected signup should not create the user")
+	}
+
+	if _, err := app.SignUp("frank", "Pale-Orbit-Kettle-42", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if _, err := app.SignIn("frank", "Pale-Orbit-Kettle-42"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	if err := app.ChangeMasterPassword("Pale-Orbit-Kettle-42", "letmein"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("ChangeMasterPassword to a weak password should give ErrWeakPassword, got %v", err)
+	}
+
+	app.MinMasterPasswordScore = 0
+	if err := app.ChangeMasterPassword("Pale-Orbit-Kettle-42", "letmein"); err != nil {
+		t.Errorf("A zero minimum should accept any password, got %v", err)
+	}
+}
+
+func TestSignOutDuringRequests(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("grace", "Mossy-Anchor-Quill-19", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	box, err := app.SealForUser("grace", []byte("shared secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Readers use the keys while they are wiped and replaced. Without the lock this can crash,
+	//and go test -race reports it every time.
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for range 4 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if opened, err := app.OpenSealedBox(box); err == nil && string(opened) != "shared secret" {
+					t.Errorf("OpenSealedBox returned %q", opened)
+				}
+			}
+		}()
+	}
+	for range 20 {
+		if _, err := app.SignIn("grace", "Mossy-Anchor-Quill-19"); err != nil {
+			t.Errorf("SignIn failed: %v", err)
+		}
+		app.SignIn("grace", "wrong")
+		app.SignOut()
+	}
+	close(done)
+	readers.Wait()
+}
+
+func TestSignUpKeepsLegacyVault(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	//An account from before each user had a vault of their own
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.SaveUser(&user.User{Username: "legacy", MasterSalt: salt}); err != nil {
+		t.Fatal(err)
+	}
+	legacyKey := crypto.GetDerivedKey([]byte("hunter2"), salt, crypto.LEGACY_PBKDF2_ITERATIONS)
+	sealed, err := vault.SealVault([]vault.Credential{{ID: "1", Password: "secret"}}, nil, legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appDir, err := vault.GetAppConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(appDir, "default.vault"), sealed, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	//A user named like the legacy vault must not overwrite it
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("default", "Velvet-Comet-Harbor-63", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if _, err := app.SignIn("legacy", "hunter2"); err != nil {
+		t.Fatalf("SignIn of the legacy user failed: %v", err)
+	}
+	if len(app.DecryptedVault) != 1 || app.DecryptedVault[0].Password != "secret" {
+		t.Errorf("The legacy vault should be intact, got %+v", app.DecryptedVault)
+	}
+	app.SignOut()
+	if _, err := app.SignIn("default", "Velvet-Comet-Harbor-63"); err != nil || len(app.DecryptedVault) != 0 {
+		t.Errorf("SignIn of default returned %d items, %v", len(app.DecryptedVault), err)
+	}
+}
+
+func TestAddCredentialAfterSignOut(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("ivan", "Pale-Orbit-Kettle-42", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if err := app.AddCredential(vault.Credential{URL: "https://example.com"}); !errors.Is(err, ErrVaultLocked) {
+		t.Errorf("Adding before sign in should give ErrVaultLocked, got %v", err)
+	}
+	if _, err := app.SignIn("ivan", "Pale-Orbit-Kettle-42"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	app.SignOut()
+	if err := app.AddCredential(vault.Credential{URL: "https://example.com"}); !errors.Is(err, ErrVaultLocked) {
+		t.Errorf("Adding after sign out should give ErrVaultLocked, got %v", err)
+	}
+}
+
+func TestLegacyKeySlotsRewrapped(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	signup, err := app.SignUp("judy", "Amber-Tundra-Piston-58", SignUpOptions{CreateRecoveryKey: true})
+	if err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+
+	//Wrap both slots the way vaults from before the key-wrap subkey did
+	stored, err := user.GetUser("judy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	masterKey, err := deriveMasterKey("Amber-Tundra-Piston-58", stored.MasterSalt, stored.KeyDerivation(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer masterKey.Destroy()
+	recoveryKey, err := crypto.ParseRecoveryKey(signup.RecoveryKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, sealed, err := vault.LoadVault("judy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultKey, err := header.UnwrapKey(vault.SlotPassword, masterKey.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, err := vault.OpenVault(header, sealed, vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, slot := range header.KeySlots {
+		wrappingKey := masterKey.Bytes()
+		if slot.Kind == vault.SlotRecovery {
+			wrappingKey = recoveryKey
+		}
+		nonce, wrapped, err := crypto.DefaultCipherSuite.Encrypt(wrappingKey, vaultKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header.KeySlots[i] = vault.KeySlot{Kind: slot.Kind, Cipher: crypto.DefaultCipherSuite.Name(), Nonce: nonce, WrappedKey: wrapped}
+	}
+	if err := vault.EncryptAndSaveVault("judy", credentials, header, vaultKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.SignIn("judy", "Amber-Tundra-Piston-58"); err != nil {
+		t.Fatalf("SignIn with a legacy slot failed: %v", err)
+	}
+	header, _, err = vault.LoadVault("judy")
+	if err != nil || header.HasLegacyKeySlot(vault.SlotPassword) {
+		t.Fatalf("Signing in should wrap the password slot under the subkey, %v", err)
+	}
+	if !header.HasLegacyKeySlot(vault.SlotRecovery) {
+		t.Fatal("The recovery slot can only be wrapped again with the recovery key")
+	}
+	app.SignOut()
+
+	if err := app.SignInWithRecoveryKey("judy", signup.RecoveryKey, "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Fatalf("SignInWithRecoveryKey with a legacy slot failed: %v", err)
+	}
+	header, _, err = vault.LoadVault("judy")
+	if err != nil || header.HasLegacyKeySlot(vault.SlotRecovery) {
+		t.Fatalf("Recovery should wrap the recovery slot under the subkey, %v", err)
+	}
+	app.SignOut()
+	if err := app.SignInWithRecoveryKey("judy", signup.RecoveryKey, "Velvet-Comet-Harbor-63"); err != nil {
+		t.Errorf("The re-wrapped recovery slot should still open: %v", err)
+	}
+}
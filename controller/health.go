@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"PasswordManager/breach"
+	"PasswordManager/strength"
+	"PasswordManager/vault"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of problems the health check reports
+const (
+	FindingBreached    string = "breached"
+	FindingWeak        string = "weak"
+	FindingReused      string = "reused"
+	FindingOld         string = "old"
+	FindingInsecureURL string = "insecure-url"
+	FindingMissing2FA  string = "missing-2fa"
+)
+
+// Passwords not changed for this many days are reported as old
+const passwordMaxAgeDays int = 365
+
+// Points an item loses for each kind of finding, out of 100
+var findingPenalties = map[string]int{
+	FindingBreached:    50,
+	FindingWeak:        40,
+	FindingReused:      30,
+	FindingOld:         15,
+	FindingInsecureURL: 10,
+	FindingMissing2FA:  5,
+}
+
+// Sites that offer authenticator app (TOTP) codes, so an item for them is expected to hold a
+// TOTP secret. Subdomains are covered too.
+var totpSites = []string{
+	"amazon.com", "apple.com", "atlassian.com", "aws.amazon.com", "binance.com", "bitbucket.org",
+	"bitwarden.com", "cloudflare.com", "coinbase.com", "digitalocean.com", "discord.com",
+	"docker.com", "dropbox.com", "facebook.com", "github.com", "gitlab.com", "godaddy.com",
+	"google.com", "heroku.com", "instagram.com", "kraken.com", "linkedin.com", "linode.com",
+	"live.com", "mailchimp.com", "microsoft.com", "namecheap.com", "npmjs.com", "nvidia.com",
+	"okta.com", "paypal.com", "proton.me", "protonmail.com", "pypi.org", "reddit.com",
+	"salesforce.com", "shopify.com", "slack.com", "snapchat.com", "stripe.com", "tiktok.com",
+	"twitch.tv", "twitter.com", "x.com", "vercel.com", "wordpress.com", "zoom.us",
+}
+
+// One problem with an item
+type HealthFinding struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+	//IDs of the other items with the same password, for reused passwords
+	ReusedWith []string `json:"reusedWith,omitempty"`
+}
+
+// Health of one item. Never holds the password.
+type ItemHealth struct {
+	ID    string `json:"id"`
+	Title string `json:"title,omitempty"`
+	URL   string `json:"url"`
+	//Strength score of the password from 0 to 4, -1 for items without a password
+	Strength int             `json:"strength"`
+	Findings []HealthFinding `json:"findings"`
+	//100 minus the penalties of the findings
+	Score int `json:"score"`
+}
+
+// Outcome of checking every item in the vault
+type HealthReport struct {
+	//Average item score from 0 to 100, 100 for an empty vault
+	Score int `json:"score"`
+	Total int `json:"total"`
+	//Whether passwords were looked up in an imported breach dataset
+	BreachChecked bool `json:"breachChecked"`
+	//Number of items with each kind of finding
+	Counts map[string]int `json:"counts"`
+	//Items with findings, lowest score first
+	Items []ItemHealth `json:"items"`
+}
+
+// Checks every password in the decrypted vault for weakness, reuse, age, sign-in pages
+// without HTTPS and missing TOTP secrets on sites that offer them. Passwords are also looked
+// up in the breach dataset when one was imported, without any network access.
+func (app *App) CheckVaultHealth() (HealthReport, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if !app.IsVaultLoaded {
+		return HealthReport{}, ErrVaultLocked
+	}
+	now := time.Now()
+	report := HealthReport{Score: 100, Total: len(app.DecryptedVault), Counts: map[string]int{}, Items: []ItemHealth{}}
+
+	breaches, err := breach.Open()
+	if err != nil && !errors.Is(err, breach.ErrNoDataset) {
+		return HealthReport{}, fmt.Errorf("Could not check the vault. %w", err)
+	}
+	if breaches != nil {
+		defer breaches.Close()
+		report.BreachChecked = true
+	}
+
+	//Items sharing each password
+	byPassword := map[string][]string{}
+	for _, cred := range app.DecryptedVault {
+		if cred.Password != "" {
+			byPassword[cred.Password] = append(byPassword[cred.Password], cred.ID)
+		}
+	}
+
+	totalScore := 0
+	for _, cred := range app.DecryptedVault {
+		item := ItemHealth{ID: cred.ID, Title: cred.Title, URL: cred.URL, Strength: -1, Findings: []HealthFinding{}}
+		host := credentialHost(cred.URL)
+
+		if cred.Password != "" {
+			result := strength.Estimate(cred.Password, cred.Username, host)
+			item.Strength = result.Score
+			if breaches != nil {
+				seen, err := breaches.Count(cred.Password)
+				if err != nil {
+					return HealthReport{}, fmt.Errorf("Could not check the vault. %w", err)
+				}
+				if seen > 0 {
+					item.Findings = append(item.Findings, HealthFinding{Kind: FindingBreached, Detail: fmt.Sprintf("Seen %d times in data breaches", seen)})
+				}
+			}
+			if result.Score < strength.SCORE_SAFELY_UNGUESSABLE {
+				item.Findings = append(item.Findings, HealthFinding{Kind: FindingWeak, Detail: result.Feedback.Warning})
+			}
+			if ids := byPassword[cred.Password]; len(ids) > 1 {
+				others := make([]string, 0, len(ids)-1)
+				for _, id := range ids {
+					if id != cred.ID {
+						others = append(others, id)
+					}
+				}
+				item.Findings = append(item.Findings, HealthFinding{
+					Kind:       FindingReused,
+					Detail:     fmt.Sprintf("Same password as %d other items", len(others)),
+					ReusedWith: others,
+				})
+			}
+		}
+		//Items saved before change dates were kept have no age
+		if !cred.PasswordChangedAt.IsZero() {
+			if days := int(now.Sub(cred.PasswordChangedAt).Hours() / 24); days > passwordMaxAgeDays {
+				item.Findings = append(item.Findings, HealthFinding{Kind: FindingOld, Detail: fmt.Sprintf("Not changed in %d days", days)})
+			}
+		}
+		if strings.HasPrefix(strings.ToLower(cred.URL), "http://") {
+			item.Findings = append(item.Findings, HealthFinding{Kind: FindingInsecureURL, Detail: "The sign-in page does not use HTTPS"})
+		}
+		if expectsTOTP(host) && !hasTOTPSecret(cred) {
+			item.Findings = append(item.Findings, HealthFinding{Kind: FindingMissing2FA, Detail: "This site offers authenticator app codes"})
+		}
+
+		item.Score = 100
+		for _, finding := range item.Findings {
+			item.Score -= findingPenalties[finding.Kind]
+			report.Counts[finding.Kind]++
+		}
+		item.Score = max(item.Score, 0)
+		totalScore += item.Score
+		if len(item.Findings) > 0 {
+			report.Items = append(report.Items, item)
+		}
+	}
+
+	if report.Total > 0 {
+		report.Score = totalScore / report.Total
+	}
+	sort.SliceStable(report.Items, func(i, j int) bool {
+		return report.Items[i].Score < report.Items[j].Score
+	})
+	return report, nil
+}
+
+// Lower case host name of an item's URL, which may lack a scheme
+func credentialHost(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+func expectsTOTP(host string) bool {
+	for _, site := range totpSites {
+		if host == site || strings.HasSuffix(host, "."+site) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the item keeps a TOTP secret, either its own or as an otpauth:// URI in a custom field
+func hasTOTPSecret(cred vault.Credential) bool {
+	if cred.OTP != "" {
+		return true
+	}
+	for _, field := range cred.Fields {
+		if strings.HasPrefix(strings.ToLower(field.Value), "otpauth://") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"PasswordManager/breach"
+	"PasswordManager/vault"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCheckVaultHealth(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+	strong := "Brisk-Lantern-Fjord-87"
+	recent := time.Now().AddDate(0, -1, 0)
+	app := &App{
+		IsVaultLoaded: true,
+		DecryptedVault: []vault.Credential{
+			{ID: "1", Title: "Fine", URL: "https://bank.example", Password: "Velvet-Comet-Harbor-63", PasswordChangedAt: recent},
+			{ID: "2", Title: "Weak", URL: "https://shop.example", Password: "password1", PasswordChangedAt: recent},
+			{ID: "3", Title: "Reused A", URL: "https://a.example", Password: strong, PasswordChangedAt: recent},
+			{ID: "4", Title: "Reused B", URL: "https://b.example", Password: strong, PasswordChangedAt: recent},
+			{ID: "5", Title: "Old", URL: "https://old.example", Password: "Mossy-Anchor-Quill-19", PasswordChangedAt: time.Now().AddDate(-2, 0, 0)},
+			{ID: "6", Title: "Plain HTTP", URL: "http://router.example", Password: "Amber-Tundra-Piston-58", PasswordChangedAt: recent},
+			{ID: "7", Title: "GitHub", URL: "https://github.com/login", Password: "Pale-Orbit-Kettle-42", PasswordChangedAt: recent},
+			{ID: "8", Title: "GitLab", URL: "gitlab.com", Password: "Quartz-Meadow-Falcon-31", PasswordChangedAt: recent,
+				Fields: []vault.CustomField{{Name: "TOTP", Value: "otpauth://totp/GitLab?secret=JBSWY3DPEHPK3PXP"}}},
+			{ID: "9", Title: "Legacy", URL: "https://legacy.example", Password: "Cobalt-Willow-Saddle-74"},
+		},
+	}
+
+	report, err := app.CheckVaultHealth()
+	if err != nil {
+		t.Fatalf("CheckVaultHealth failed: %v", err)
+	}
+	if report.Total != 9 || report.BreachChecked {
+		t.Errorf("Expected 9 items checked without breach data, got %d, %v", report.Total, report.BreachChecked)
+	}
+
+	kinds := map[string][]string{}
+	for _, item := range report.Items {
+		for _, finding := range item.Findings {
+			kinds[item.ID] = append(kinds[item.ID], finding.Kind)
+		}
+	}
+	expected := map[string][]string{
+		"2": {FindingWeak},
+		"3": {FindingReused},
+		"4": {FindingReused},
+		"5": {FindingOld},
+		"6": {FindingInsecureURL},
+		"7": {FindingMissing2FA},
+	}
+	for id, want := range expected {
+		if !slices.Equal(kinds[id], want) {
+			t.Errorf("Item %s: expected findings %v, got %v", id, want, kinds[id])
+		}
+	}
+	for _, id := range []string{"1", "8", "9"} {
+		if len(kinds[id]) > 0 {
+			t.Errorf("Item %s should be healthy, got %v", id, kinds[id])
+		}
+	}
+
+	for _, item := range report.Items {
+		if item.ID == "3" && !slices.Equal(item.Findings[0].ReusedWith, []string{"4"}) {
+			t.Errorf("Item 3 should be reused with item 4, got %v", item.Findings[0].ReusedWith)
+		}
+	}
+	if report.Items[0].ID != "2" {
+		t.Errorf("The weakest item should come first, got %s", report.Items[0].ID)
+	}
+	if report.Counts[FindingReused] != 2 || report.Counts[FindingWeak] != 1 {
+		t.Errorf("Unexpected counts %v", report.Counts)
+	}
+	//Three healthy items, then 60, 70, 70, 85, 90 and 95
+	if want := (3*100 + 60 + 70 + 70 + 85 + 90 + 95) / 9; report.Score != want {
+		t.Errorf("Expected vault score %d, got %d", want, report.Score)
+	}
+
+	//With breach data imported, a breached password is reported as well
+	digest := sha1.Sum([]byte("password1"))
+	source := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(source, []byte(hex.EncodeToString(digest[:])+":2418984\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := breach.Import(source); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	report, err = app.CheckVaultHealth()
+	if err != nil || !report.BreachChecked {
+		t.Fatalf("Expected breach data to be checked, got %v, %v", report.BreachChecked, err)
+	}
+	if report.Items[0].ID != "2" || !slices.Equal([]string{report.Items[0].Findings[0].Kind, report.Items[0].Findings[1].Kind}, []string{FindingBreached, FindingWeak}) {
+		t.Errorf("Item 2 should be breached and weak, got %+v", report.Items[0])
+	}
+	if report.Counts[FindingBreached] != 1 {
+		t.Errorf("Expected one breached item, got %v", report.Counts)
+	}
+
+	empty, err := (&App{IsVaultLoaded: true}).CheckVaultHealth()
+	if err != nil || empty.Score != 100 || len(empty.Items) != 0 {
+		t.Errorf("An empty vault should be healthy, got %+v, %v", empty, err)
+	}
+	if _, err := (&App{}).CheckVaultHealth(); !errors.Is(err, ErrVaultLocked) {
+		t.Errorf("Expected ErrVaultLocked for a locked vault, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"PasswordManager/crypto"
+	"PasswordManager/vault"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+const kdfPolicyFileName string = "kdf-policy.json"
+
+// KDF policy calibrated on an earlier start and the unlock time it was calibrated to
+type storedKDFPolicy struct {
+	Target time.Duration    `json:"target"`
+	Params crypto.KDFParams `json:"params"`
+}
+
+// Returns the KDF policy for new accounts calibrated to target. The policy is calibrated once
+// and stored in the app directory, then reused until target changes or recalibrate is set.
+// Calibrating at every start would let timing noise raise the policy now and then, and every
+// account would be upgraded at its next sign-in.
+func CalibratedKDFPolicy(target time.Duration, recalibrate bool) (crypto.KDFParams, error) {
+	appDir, err := vault.GetAppConfigDir()
+	if err != nil {
+		return crypto.KDFParams{}, fmt.Errorf("Could not load the KDF policy. %w", err)
+	}
+	policyPath := path.Join(appDir, kdfPolicyFileName)
+
+	if !recalibrate {
+		//A missing or damaged file is calibrated again
+		var stored storedKDFPolicy
+		if data, err := os.ReadFile(policyPath); err == nil && json.Unmarshal(data, &stored) == nil {
+			if stored.Target == target && stored.Params.Validate() == nil {
+				return stored.Params, nil
+			}
+		}
+	}
+
+	params, err := crypto.CalibrateKDF(target)
+	if err != nil {
+		return crypto.KDFParams{}, err
+	}
+	data, err := json.MarshalIndent(storedKDFPolicy{Target: target, Params: params}, "", "  ")
+	if err != nil {
+		return crypto.KDFParams{}, fmt.Errorf("Could not store the KDF policy. %w", err)
+	}
+	if err := vault.CommitFiles(map[string][]byte{policyPath: data}); err != nil {
+		return crypto.KDFParams{}, fmt.Errorf("Could not store the KDF policy. %w", err)
+	}
+	return params, nil
+}
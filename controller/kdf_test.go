@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalibratedKDFPolicyIsReused(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	first, err := CalibratedKDFPolicy(time.Millisecond, false)
+	if err != nil {
+		t.Fatalf("CalibratedKDFPolicy failed: %v", err)
+	}
+	second, err := CalibratedKDFPolicy(time.Millisecond, false)
+	if err != nil {
+		t.Fatalf("CalibratedKDFPolicy failed: %v", err)
+	}
+	if second != first {
+		t.Fatalf("A second start should reuse the stored policy, got %+v and %+v", first, second)
+	}
+
+	app := NewApp()
+	app.KDFPolicy = first
+	if _, err := app.SignUp("heidi", "Brisk-Lantern-Fjord-87", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	app = NewApp()
+	app.KDFPolicy = second
+	result, err := app.SignIn("heidi", "Brisk-Lantern-Fjord-87")
+	if err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	if result.KDFUpgraded {
+		t.Error("Restarting must not upgrade accounts created under the stored policy")
+	}
+
+	//A new target is calibrated again
+	if params, err := CalibratedKDFPolicy(time.Hour, false); err != nil || params.Time <= first.Time {
+		t.Errorf("A longer target should be calibrated to more passes, got %+v, %v", params, err)
+	}
+}
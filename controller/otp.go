@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"PasswordManager/audit"
+	"PasswordManager/otp"
+	"PasswordManager/vault"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNoOTP = errors.New("item has no one-time password")
+
+// A one-time code of an item, without the seed it came from
+type OTPCode struct {
+	Code string `json:"code"`
+	Type string `json:"type"`
+	//Seconds a TOTP code is valid for and how many of them are left
+	Period    int `json:"period,omitempty"`
+	Remaining int `json:"remaining,omitempty"`
+	//Counter the HOTP code was made from
+	Counter uint64 `json:"counter,omitempty"`
+}
+
+// Returns the current code of an item's TOTP or HOTP generator and records the access in the
+// audit log. An HOTP code is used up: the counter moves on and is saved with the vault.
+func (app *App) GenerateOTPCode(id string) (OTPCode, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	code, err := app.otpCode(id, time.Now())
+	if auditErr := app.recordAudit(audit.Entry{Action: audit.ActionOTPCode, ItemID: id}, err); auditErr != nil {
+		return OTPCode{}, auditErr
+	}
+	return code, err
+}
+
+func (app *App) otpCode(id string, now time.Time) (OTPCode, error) {
+	if !app.IsVaultLoaded {
+		return OTPCode{}, ErrVaultLocked
+	}
+	if id == "" {
+		return OTPCode{}, ErrCredentialNotFound
+	}
+	for i := range app.DecryptedVault {
+		cred := &app.DecryptedVault[i]
+		if cred.ID != id {
+			continue
+		}
+		if app.needsReprompt(cred) {
+			return OTPCode{}, ErrRepromptRequired
+		}
+		if cred.OTP == "" {
+			return OTPCode{}, ErrNoOTP
+		}
+		key, err := otp.ParseURI(cred.OTP)
+		if err != nil {
+			return OTPCode{}, fmt.Errorf("Could not generate a code. %w", err)
+		}
+
+		if key.Type == otp.TYPE_TOTP {
+			code, remaining := key.TOTP(now)
+			return OTPCode{Code: code, Type: key.Type, Period: key.Period, Remaining: remaining}, nil
+		}
+		code := OTPCode{Code: otp.HOTP(key.Secret, key.Counter, key.Digits, key.Algorithm), Type: key.Type, Counter: key.Counter}
+		key.Counter++
+		if err := app.updateOTP(cred, key.URI()); err != nil {
+			return OTPCode{}, fmt.Errorf("Could not generate a code. %w", err)
+		}
+		return code, nil
+	}
+	return OTPCode{}, ErrCredentialNotFound
+}
+
+// Stores a new otpauth URI on cred and saves the vault, keeping the old one if saving fails
+func (app *App) updateOTP(cred *vault.Credential, uri string) error {
+	previous := cred.OTP
+	cred.OTP = uri
+	if err := vault.EncryptAndSaveVault(app.CurrentUser.Username, app.DecryptedVault, app.vaultHeader, app.key.Bytes()); err != nil {
+		cred.OTP = previous
+		return err
+	}
+	return nil
+}
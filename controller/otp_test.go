@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"PasswordManager/otp"
+	"PasswordManager/vault"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateOTPCode(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("alice", "Pale-Orbit-Kettle-42", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if _, err := app.SignIn("alice", "Pale-Orbit-Kettle-42"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+
+	//The RFC test secret "12345678901234567890"
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	items := []vault.Credential{
+		{Title: "Plain", URL: "https://plain.example"},
+		{Title: "TOTP", URL: "https://totp.example", OTP: "otpauth://totp/Example:alice?secret=" + strings.ToLower(secret) + "&digits=8"},
+		{Title: "HOTP", URL: "https://hotp.example", OTP: "otpauth://hotp/Example:alice?secret=" + secret + "&counter=0"},
+	}
+	for _, item := range items {
+		if err := app.AddCredential(item); err != nil {
+			t.Fatalf("AddCredential failed: %v", err)
+		}
+	}
+	if err := app.AddCredential(vault.Credential{URL: "https://bad.example", OTP: "otpauth://totp/x?secret=!!"}); !errors.Is(err, otp.ErrInvalidURI) {
+		t.Errorf("A broken otpauth URI should be refused, got %v", err)
+	}
+	plain, totp, hotp := app.DecryptedVault[0], app.DecryptedVault[1], app.DecryptedVault[2]
+	if !strings.Contains(totp.OTP, "secret="+secret) || !strings.Contains(totp.OTP, "period=30") {
+		t.Errorf("The otpauth URI should be stored normalized, got %q", totp.OTP)
+	}
+
+	code, err := app.otpCode(totp.ID, time.Unix(59, 0))
+	if err != nil || code.Code != "94287082" || code.Remaining != 1 || code.Period != 30 {
+		t.Errorf("Unexpected TOTP code %+v, %v", code, err)
+	}
+	if code, err := app.GenerateOTPCode(totp.ID); err != nil || len(code.Code) != 8 || code.Remaining < 1 || code.Remaining > 30 {
+		t.Errorf("Unexpected current TOTP code %+v, %v", code, err)
+	}
+
+	//Each HOTP code is used once and the counter survives signing out
+	for counter, want := range []string{"755224", "287082"} {
+		code, err := app.GenerateOTPCode(hotp.ID)
+		if err != nil || code.Code != want || code.Counter != uint64(counter) {
+			t.Errorf("HOTP code %d: expected %s, got %+v, %v", counter, want, code, err)
+		}
+	}
+	app.SignOut()
+	if _, err := app.SignIn("alice", "Pale-Orbit-Kettle-42"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	if code, err := app.GenerateOTPCode(hotp.ID); err != nil || code.Code != "359152" {
+		t.Errorf("The HOTP counter should have been saved, got %+v, %v", code, err)
+	}
+
+	if _, err := app.GenerateOTPCode(plain.ID); !errors.Is(err, ErrNoOTP) {
+		t.Errorf("Expected ErrNoOTP for an item without a generator, got %v", err)
+	}
+	if _, err := app.GenerateOTPCode("missing"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("Expected ErrCredentialNotFound, got %v", err)
+	}
+
+	//The seed never reaches the browser
+	redacted, _ := json.Marshal(app.GetCredentialsForDisplay())
+	if strings.Contains(string(redacted), secret) || !strings.Contains(string(redacted), `"hasOtp":true`) {
+		t.Errorf("Redacted items should only flag the generator, got %s", redacted)
+	}
+	if _, err := app.RevealCredentialField(totp.ID, "otp"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("The seed should not be revealable, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package controller
+
+import (
+	"PasswordManager/audit"
+	"PasswordManager/crypto"
+	"PasswordManager/user"
+	"PasswordManager/vault"
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"time"
+)
+
+var (
+	ErrNoRecoveryKey    = errors.New("vault has no recovery key")
+	ErrNoRecoveryShares = errors.New("vault has no recovery shares")
+	ErrNotEnoughShares  = errors.New("not enough recovery shares")
+)
+
+// Unlocks the vault with the recovery key instead of the master password. Since the master
+// password is presumed lost, newPassword immediately replaces it. A keyfile may be lost along
+// with it, so the new master key is derived from newPassword alone.
+func (app *App) SignInWithRecoveryKey(username string, recoveryKey string, newPassword string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	err := app.signInWithRecoveryKey(username, recoveryKey, newPassword)
+	return app.finishRecovery(username, "", err)
+}
+
+func (app *App) signInWithRecoveryKey(username string, recoveryKey string, newPassword string) error {
+	key, err := crypto.ParseRecoveryKey(recoveryKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWrongPassword, err)
+	}
+	return app.recoverVault(username, newPassword, func(header *vault.Header) ([]byte, string, error) {
+		if header == nil || !header.HasKeySlot(vault.SlotRecovery) {
+			return nil, "", ErrNoRecoveryKey
+		}
+		return key, vault.SlotRecovery, nil
+	})
+}
+
+// Splits a new key into total printable shares, any threshold of which unlock the vault with
+// SignInWithRecoveryShares like a recovery key does. The master password is asked again since
+// the shares give full access. Creating shares again invalidates the previous ones.
+func (app *App) CreateRecoveryShares(masterPassword string, total int, threshold int) ([]string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	shares, err := app.createRecoveryShares(masterPassword, total, threshold)
+	entry := audit.Entry{Action: audit.ActionCreateRecoveryShares, Detail: fmt.Sprintf("%d of %d", threshold, total)}
+	if auditErr := app.recordAudit(entry, err); auditErr != nil {
+		return nil, auditErr
+	}
+	return shares, err
+}
+
+func (app *App) createRecoveryShares(masterPassword string, total int, threshold int) ([]string, error) {
+	if err := app.checkMasterPassword(masterPassword); err != nil {
+		return nil, err
+	}
+	if app.vaultHeader == nil {
+		return nil, fmt.Errorf("Vault has no key slots yet")
+	}
+
+	sharedKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(sharedKey)
+	rawShares, err := crypto.SplitSecret(sharedKey, total, threshold)
+	if err != nil {
+		return nil, err
+	}
+	header := app.vaultHeader.Clone()
+	if err := header.SetKeySlot(vault.SlotShares, app.key.Bytes(), sharedKey); err != nil {
+		return nil, err
+	}
+	header.ShareThreshold = threshold
+	if err := app.commitUserAndVault(nil, header, app.key); err != nil {
+		return nil, fmt.Errorf("Could not save the recovery shares. %w", err)
+	}
+
+	shares := make([]string, len(rawShares))
+	for i, share := range rawShares {
+		shares[i] = crypto.FormatShare(share)
+		crypto.Wipe(share)
+	}
+	return shares, nil
+}
+
+// Unlocks the vault with recovery shares from CreateRecoveryShares and replaces the master
+// password with newPassword, like SignInWithRecoveryKey
+func (app *App) SignInWithRecoveryShares(username string, shares []string, newPassword string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	err := app.signInWithRecoveryShares(username, shares, newPassword)
+	return app.finishRecovery(username, fmt.Sprintf("%d shares", len(shares)), err)
+}
+
+func (app *App) signInWithRecoveryShares(username string, shares []string, newPassword string) error {
+	rawShares := make([][]byte, 0, len(shares))
+	defer func() {
+		for _, share := range rawShares {
+			crypto.Wipe(share)
+		}
+	}()
+	for _, formatted := range shares {
+		share, err := crypto.ParseShare(formatted)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrWrongPassword, err)
+		}
+		rawShares = append(rawShares, share)
+	}
+
+	return app.recoverVault(username, newPassword, func(header *vault.Header) ([]byte, string, error) {
+		if header == nil || !header.HasKeySlot(vault.SlotShares) {
+			return nil, "", ErrNoRecoveryShares
+		}
+		if len(rawShares) < header.ShareThreshold {
+			return nil, "", fmt.Errorf("%w: %d of %d given", ErrNotEnoughShares, len(rawShares), header.ShareThreshold)
+		}
+		key, err := crypto.CombineShares(rawShares)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrWrongPassword, err)
+		}
+		return key, vault.SlotShares, nil
+	})
+}
+
+// Signs out after a failed recovery and audits the attempt
+func (app *App) finishRecovery(username string, detail string, err error) error {
+	if err != nil {
+		app.signOut()
+	}
+	entry := audit.Entry{Action: audit.ActionRecoverySignIn, Username: username, Detail: detail}
+	if auditErr := app.recordAudit(entry, err); auditErr != nil {
+		app.signOut()
+		return auditErr
+	}
+	return err
+}
+
+// Loads the vault of username and unwraps the vault key with the wrapping key and slot that
+// unlockKey picks from the header, then sets newPassword as the master password
+func (app *App) recoverVault(username string, newPassword string, unlockKey func(*vault.Header) ([]byte, string, error)) error {
+	app.IsVaultLoaded = false
+	if newPassword == "" {
+		return fmt.Errorf("A new master password is required")
+	}
+	if err := app.checkPasswordStrength(newPassword, username); err != nil {
+		return err
+	}
+
+	//Finish a user/vault update that was interrupted
+	err := vault.RecoverPendingCommit()
+	if err != nil {
+		return fmt.Errorf("Could not recover an interrupted update. %w", err)
+	}
+	app.CurrentUser, err = user.GetUser(username)
+	if err != nil {
+		return fmt.Errorf("User %q does not Exist.: %v", username, err.Error())
+	}
+	if app.CurrentUser == nil {
+		return ErrWrongPassword
+	}
+
+	header, sealed, err := vault.LoadVault(username)
+	if errors.Is(err, vault.ErrVaultMissing) {
+		return ErrVaultMissing
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVaultCorrupt, err)
+	}
+	key, slot, err := unlockKey(header)
+	if err != nil {
+		return err
+	}
+	app.key, err = unwrapVaultKey(header, slot, key)
+	if err != nil {
+		crypto.Wipe(key)
+		return fmt.Errorf("%w: %v", ErrWrongPassword, err)
+	}
+	//A slot from before the key-wrap subkey is wrapped again and committed along with the new
+	//master password below
+	if header.HasLegacyKeySlot(slot) {
+		if err := header.SetKeySlot(slot, app.key.Bytes(), key); err != nil {
+			log.Printf("Re-wrapping the %s slot of %q failed: %v", slot, username, err)
+		}
+	}
+	crypto.Wipe(key)
+	app.vaultHeader = header
+	//The unwrap is authenticated, so the key is right and any failure here is damage
+	app.DecryptedVault, err = vault.OpenVault(header, sealed, app.key.Bytes())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVaultCorrupt, err)
+	}
+	app.IsVaultLoaded = true
+	if err := vault.ClaimLegacyVault(username); err != nil {
+		log.Printf("Moving the shared vault to %q failed: %v", username, err)
+	}
+	if err := app.assignMissingIDs(); err != nil {
+		log.Printf("Saving new item IDs for %q failed: %v", username, err)
+	}
+
+	if err := app.setMasterPassword(newPassword); err != nil {
+		return fmt.Errorf("Could not set the new master password. %w", err)
+	}
+	return nil
+}
+
+var emergencyKitTemplate = template.Must(template.New("kit").Parse(`<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8" />
+	<title>Emergency Kit for {{.Username}}</title>
+	<style>
+		body { font-family: 'Inter', sans-serif; max-width: 700px; margin: 40px auto; color: #333; }
+		h1 { color: #2c3e50; }
+		.box { border: 2px solid #2c3e50; border-radius: 8px; padding: 16px; margin: 16px 0; }
+		.label { font-weight: 600; color: #555; }
+		.key { font-family: monospace; font-size: 1.3em; letter-spacing: 1px; word-break: break-all; }
+		.blank { border-bottom: 1px solid #333; height: 32px; }
+	</style>
+</head>
+<body>
+	<h1>Emergency Kit</h1>
+	<p>Created {{.Created}}. Print this page and keep it somewhere safe, away from your computer.</p>
+	<div class="box">
+		<div class="label">Username</div>
+		<div class="key">{{.Username}}</div>
+	</div>
+	<div class="box">
+		<div class="label">Recovery Key</div>
+		<div class="key">{{.RecoveryKey}}</div>
+	</div>
+	<div class="box">
+		<div class="label">Master Password (write it down by hand, optional)</div>
+		<div class="blank"></div>
+	</div>
+	<h2>If you forget your master password</h2>
+	<ol>
+		<li>Open the sign in page and choose "Use recovery key".</li>
+		<li>Enter your username and the recovery key above.</li>
+		<li>Choose a new master password. Your vault is unlocked with it from then on.</li>
+	</ol>
+	<p>Anyone holding this recovery key can open your vault. It is not stored anywhere else and cannot be shown again.</p>
+</body>
+</html>
+`))
+
+// Renders a standalone, printable HTML page holding the username and recovery key
+func EmergencyKit(username string, recoveryKey string) ([]byte, error) {
+	key, err := crypto.ParseRecoveryKey(recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	var page bytes.Buffer
+	err = emergencyKitTemplate.Execute(&page, map[string]string{
+		"Username":    username,
+		"RecoveryKey": crypto.FormatRecoveryKey(key),
+		"Created":     time.Now().Format("2 January 2006"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Could not render emergency kit. %w", err)
+	}
+	return page.Bytes(), nil
+}
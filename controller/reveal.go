@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"PasswordManager/audit"
+	"PasswordManager/vault"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// How long a successful master password re-prompt stays valid by default
+const defaultRepromptWindow time.Duration = 5 * time.Minute
+
+var (
+	ErrVaultLocked        = errors.New("vault is locked")
+	ErrCredentialNotFound = errors.New("credential not found")
+	ErrFieldNotFound      = errors.New("field not found")
+	ErrRepromptRequired   = errors.New("master password re-prompt required")
+	ErrWrongReprompt      = errors.New("master password does not match")
+)
+
+// Re-derives the master key from password and compares it in constant time with the one that
+// unlocked the vault. A match counts as a fresh re-prompt for RepromptWindow.
+func (app *App) VerifyMasterPassword(password string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	err := app.checkMasterPassword(password)
+	if err == nil {
+		app.lastReprompt = time.Now()
+	}
+	if auditErr := app.recordAudit(audit.Entry{Action: audit.ActionReprompt}, err); auditErr != nil {
+		return auditErr
+	}
+	return err
+}
+
+func (app *App) checkMasterPassword(password string) error {
+	if !app.IsVaultLoaded {
+		return ErrVaultLocked
+	}
+	candidate, err := deriveMasterKey(password, app.CurrentUser.MasterSalt, app.CurrentUser.KeyDerivation(), app.keyfileKey)
+	if err != nil {
+		return fmt.Errorf("Could not verify master password. %w", err)
+	}
+	defer candidate.Destroy()
+	if subtle.ConstantTimeCompare(candidate.Bytes(), app.masterKey.Bytes()) != 1 {
+		return ErrWrongReprompt
+	}
+	return nil
+}
+
+// Reports whether the master password was re-entered within RepromptWindow
+func (app *App) hasRecentReprompt() bool {
+	return !app.lastReprompt.IsZero() && time.Since(app.lastReprompt) <= app.RepromptWindow
+}
+
+// Reports whether handing out secrets of cred needs a fresh re-prompt that has not happened
+func (app *App) needsReprompt(cred *vault.Credential) bool {
+	return (app.RequireRepromptForReveal || cred.RequireReprompt) && !app.hasRecentReprompt()
+}
+
+// Returns a single secret value of an item to be shown and records the access in the audit log
+func (app *App) RevealCredentialField(id string, field string) (string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.accessCredentialField(audit.ActionReveal, id, field)
+}
+
+// Returns a single secret value of an item to be put on the clipboard and records the access
+// in the audit log
+func (app *App) CopyCredentialField(id string, field string) (string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.accessCredentialField(audit.ActionCopy, id, field)
+}
+
+// Returns every item in plaintext for export. Items flagged with RequireReprompt make the
+// whole export wait for a fresh re-prompt.
+func (app *App) ExportCredentials() ([]vault.Credential, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	var err error
+	if !app.IsVaultLoaded {
+		err = ErrVaultLocked
+	} else {
+		for i := range app.DecryptedVault {
+			if app.needsReprompt(&app.DecryptedVault[i]) {
+				err = ErrRepromptRequired
+				break
+			}
+		}
+	}
+	if auditErr := app.recordAudit(audit.Entry{Action: audit.ActionExport}, err); auditErr != nil {
+		return nil, auditErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	exported := make([]vault.Credential, len(app.DecryptedVault))
+	copy(exported, app.DecryptedVault)
+	return exported, nil
+}
+
+func (app *App) accessCredentialField(action string, id string, field string) (string, error) {
+	value, err := app.credentialFieldValue(id, field)
+	if auditErr := app.recordAudit(audit.Entry{Action: action, ItemID: id, Field: field}, err); auditErr != nil {
+		return "", auditErr
+	}
+	return value, err
+}
+
+func (app *App) credentialFieldValue(id string, field string) (string, error) {
+	if !app.IsVaultLoaded {
+		return "", ErrVaultLocked
+	}
+	if id == "" {
+		return "", ErrCredentialNotFound
+	}
+	for i := range app.DecryptedVault {
+		cred := &app.DecryptedVault[i]
+		if cred.ID != id {
+			continue
+		}
+		if app.needsReprompt(cred) {
+			return "", ErrRepromptRequired
+		}
+		value, ok := cred.FieldValue(field)
+		if !ok {
+			return "", ErrFieldNotFound
+		}
+		return value, nil
+	}
+	return "", ErrCredentialNotFound
+}
+
+// Fills in the user and outcome of entry and writes it to the audit log. Secrets must never be
+// handed out when this fails.
+func (app *App) recordAudit(entry audit.Entry, outcome error) error {
+	if app.CurrentUser != nil {
+		entry.Username = app.CurrentUser.Username
+	}
+	entry.Success = outcome == nil
+	if outcome != nil {
+		entry.Detail = outcome.Error()
+	}
+	if err := audit.Record(entry); err != nil {
+		return fmt.Errorf("Could not write to the audit log. %w", err)
+	}
+	return nil
+}
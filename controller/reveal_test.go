@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"PasswordManager/crypto"
+	"PasswordManager/user"
+	"PasswordManager/vault"
+	"errors"
+	"testing"
+)
+
+func TestRepromptForSensitiveItems(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	salt := []byte("reprompt-test-salt")
+	app := NewApp()
+	app.CurrentUser = &user.User{Username: "alice", MasterSalt: salt}
+	masterKey, err := crypto.NewSecretBufferFrom(crypto.GetDerivedKey([]byte("correct horse"), salt, crypto.LEGACY_PBKDF2_ITERATIONS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.masterKey = masterKey
+	app.IsVaultLoaded = true
+	app.DecryptedVault = []vault.Credential{
+		{ID: "plain", Password: "plain-secret"},
+		{ID: "bank", Password: "bank-secret", RequireReprompt: true},
+	}
+
+	if value, err := app.RevealCredentialField("plain", vault.PasswordField); err != nil || value != "plain-secret" {
+		t.Fatalf("Unflagged item should be revealed without re-prompt, got %q, %v", value, err)
+	}
+	if _, err := app.RevealCredentialField("bank", vault.PasswordField); !errors.Is(err, ErrRepromptRequired) {
+		t.Fatalf("Flagged item should require re-prompt, got %v", err)
+	}
+	if _, err := app.CopyCredentialField("bank", vault.PasswordField); !errors.Is(err, ErrRepromptRequired) {
+		t.Fatalf("Copying a flagged item should require re-prompt, got %v", err)
+	}
+	if _, err := app.ExportCredentials(); !errors.Is(err, ErrRepromptRequired) {
+		t.Fatalf("Export with a flagged item should require re-prompt, got %v", err)
+	}
+
+	if err := app.VerifyMasterPassword("wrong"); !errors.Is(err, ErrWrongReprompt) {
+		t.Fatalf("Wrong master password should be rejected, got %v", err)
+	}
+	if err := app.VerifyMasterPassword("correct horse"); err != nil {
+		t.Fatalf("Correct master password should be accepted, got %v", err)
+	}
+
+	if value, err := app.RevealCredentialField("bank", vault.PasswordField); err != nil || value != "bank-secret" {
+		t.Fatalf("Flagged item should be revealed after re-prompt, got %q, %v", value, err)
+	}
+	if exported, err := app.ExportCredentials(); err != nil || len(exported) != 2 {
+		t.Fatalf("Export should succeed after re-prompt, got %d items, %v", len(exported), err)
+	}
+	if _, err := app.RevealCredentialField("bank", "missing"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("Unknown field should be reported, got %v", err)
+	}
+
+	app.SignOut()
+	if app.hasRecentReprompt() {
+		t.Error("SignOut should forget the last re-prompt")
+	}
+}
+
+func TestLegacyItemsGetIDs(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	//Items saved before items had IDs
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.SaveUser(&user.User{Username: "legacy", MasterSalt: salt}); err != nil {
+		t.Fatal(err)
+	}
+	legacyKey := crypto.GetDerivedKey([]byte("hunter2"), salt, crypto.LEGACY_PBKDF2_ITERATIONS)
+	legacyItems := []vault.Credential{{URL: "https://one.example", Password: "first-secret"}, {URL: "https://two.example", Password: "second-secret"}}
+	if err := vault.EncryptAndSaveVault("legacy", legacyItems, nil, legacyKey); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignIn("legacy", "hunter2"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	ids := map[string]string{}
+	for _, cred := range app.DecryptedVault {
+		if cred.ID == "" || ids[cred.ID] != "" {
+			t.Fatalf("Every item should get its own ID, got %q", cred.ID)
+		}
+		ids[cred.ID] = cred.Password
+	}
+	for id, password := range ids {
+		if value, err := app.RevealCredentialField(id, vault.PasswordField); err != nil || value != password {
+			t.Errorf("Revealing %q returned %q, %v, want %q", id, value, err, password)
+		}
+	}
+	if _, err := app.RevealCredentialField("", vault.PasswordField); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("An empty ID should match nothing, got %v", err)
+	}
+
+	//The IDs are saved with the vault
+	app.SignOut()
+	if _, err := app.SignIn("legacy", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, cred := range app.DecryptedVault {
+		if ids[cred.ID] != cred.Password {
+			t.Errorf("Item IDs should survive signing in again, got %q", cred.ID)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"PasswordManager/vault"
+	"sort"
+	"strings"
+)
+
+// Options accepted by SearchCredentials
+type SearchOptions struct {
+	//Free text query, e.g. `github url:git tag:work`
+	Query string
+	//"relevance", "title", "url", "username" or "rotateBy". Prefix with "-" for descending order.
+	Sort   string
+	Limit  int
+	Offset int
+}
+
+// Match scores for a single term against a single field
+const (
+	scoreExact     int = 100
+	scorePrefix    int = 75
+	scoreSubstring int = 50
+	scoreFuzzy     int = 10
+)
+
+// Relative weight of each searchable field
+var searchFieldWeights = map[string]int{
+	"title":    4,
+	"url":      3,
+	"username": 3,
+	"tag":      2,
+	"notes":    1,
+	"field":    1,
+}
+
+// Qualifier aliases accepted in queries, mapped to the field they search
+var searchQualifiers = map[string]string{
+	"title":    "title",
+	"name":     "title",
+	"url":      "url",
+	"site":     "url",
+	"username": "username",
+	"user":     "username",
+	"tag":      "tag",
+	"tags":     "tag",
+	"notes":    "notes",
+	"note":     "notes",
+	"field":    "field",
+}
+
+type searchTerm struct {
+	field string //empty means any field
+	text  string
+}
+
+type scoredCredential struct {
+	cred  vault.Credential
+	score int
+	index int
+}
+
+// Searches the decrypted vault and returns the requested page of matches along with the
+// total number of matches. Every term of the query has to match for an item to be returned.
+func (app *App) SearchCredentials(opts SearchOptions) ([]vault.RedactedCredential, int) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if !app.IsVaultLoaded {
+		return nil, 0
+	}
+
+	terms := parseSearchQuery(opts.Query)
+	matches := []scoredCredential{}
+	for i, cred := range app.DecryptedVault {
+		score, ok := scoreCredential(cred, terms)
+		if !ok {
+			continue
+		}
+		matches = append(matches, scoredCredential{cred: cred, score: score, index: i})
+	}
+
+	sortMatches(matches, opts.Sort, len(terms) > 0)
+
+	total := len(matches)
+	start := min(max(opts.Offset, 0), total)
+	end := total
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, total)
+	}
+
+	page := make([]vault.RedactedCredential, 0, end-start)
+	for _, match := range matches[start:end] {
+		page = append(page, match.cred.Redact())
+	}
+	return page, total
+}
+
+func parseSearchQuery(query string) []searchTerm {
+	terms := []searchTerm{}
+	for _, token := range strings.Fields(strings.ToLower(query)) {
+		qualifier, text, found := strings.Cut(token, ":")
+		if field, known := searchQualifiers[qualifier]; found && known {
+			if text == "" {
+				continue
+			}
+			terms = append(terms, searchTerm{field: field, text: text})
+			continue
+		}
+		terms = append(terms, searchTerm{text: token})
+	}
+	return terms
+}
+
+// Returns the values of every searchable field of a credential, keyed by field name
+func searchableFields(cred vault.Credential) map[string][]string {
+	fieldNames := make([]string, 0, len(cred.Fields))
+	for _, field := range cred.Fields {
+		fieldNames = append(fieldNames, field.Name)
+	}
+	return map[string][]string{
+		"title":    {cred.Title},
+		"url":      {cred.URL},
+		"username": {cred.Username},
+		"tag":      cred.Tags,
+		"notes":    {cred.Notes},
+		"field":    fieldNames,
+	}
+}
+
+func scoreCredential(cred vault.Credential, terms []searchTerm) (int, bool) {
+	fields := searchableFields(cred)
+	total := 0
+	for _, term := range terms {
+		best := 0
+		for name, values := range fields {
+			if term.field != "" && term.field != name {
+				continue
+			}
+			for _, value := range values {
+				best = max(best, scoreText(strings.ToLower(value), term.text)*searchFieldWeights[name])
+			}
+		}
+		if best == 0 {
+			return 0, false
+		}
+		total += best
+	}
+	return total, true
+}
+
+// Scores how well term matches value. Both are expected to be lower case.
+func scoreText(value string, term string) int {
+	switch {
+	case value == "":
+		return 0
+	case value == term:
+		return scoreExact
+	case strings.HasPrefix(value, term):
+		return scorePrefix
+	case strings.Contains(value, term):
+		return scoreSubstring
+	}
+	return fuzzyScore(value, term)
+}
+
+// Matches term as a subsequence of value. Consecutive runs of matched characters score higher
+// so that "gthb" ranks "github" above "git-hub-backup".
+func fuzzyScore(value string, term string) int {
+	termRunes := []rune(term)
+	matched := 0
+	run := 0
+	bonus := 0
+	for _, r := range value {
+		if matched == len(termRunes) {
+			break
+		}
+		if r == termRunes[matched] {
+			matched++
+			run++
+			bonus += run - 1
+			continue
+		}
+		run = 0
+	}
+	if matched < len(termRunes) {
+		return 0
+	}
+	return scoreFuzzy + min(bonus, scoreSubstring-scoreFuzzy-1)
+}
+
+func sortMatches(matches []scoredCredential, order string, hasQuery bool) {
+	descending := strings.HasPrefix(order, "-")
+	key := strings.TrimPrefix(order, "-")
+	if key == "" {
+		if !hasQuery {
+			return
+		}
+		key = "relevance"
+	}
+
+	compare := func(a, b scoredCredential) int {
+		switch key {
+		case "title":
+			return strings.Compare(strings.ToLower(a.cred.Title), strings.ToLower(b.cred.Title))
+		case "url":
+			return strings.Compare(strings.ToLower(a.cred.URL), strings.ToLower(b.cred.URL))
+		case "username":
+			return strings.Compare(strings.ToLower(a.cred.Username), strings.ToLower(b.cred.Username))
+		case "rotateBy":
+			return a.cred.RotateBy.Compare(b.cred.RotateBy)
+		case "relevance":
+			//Higher score first
+			return b.score - a.score
+		}
+		return 0
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		c := compare(matches[i], matches[j])
+		if descending {
+			c = -c
+		}
+		if c == 0 {
+			return matches[i].index < matches[j].index
+		}
+		return c < 0
+	})
+}
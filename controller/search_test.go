@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"PasswordManager/vault"
+	"testing"
+)
+
+func TestSearchCredentials(t *testing.T) {
+	app := &App{
+		IsVaultLoaded: true,
+		DecryptedVault: []vault.Credential{
+			{ID: "1", Title: "GitHub", URL: "https://github.com", Username: "alice", Tags: []string{"work"}},
+			{ID: "2", Title: "Bank", URL: "https://bank.example", Username: "alice", Notes: "savings account"},
+			{ID: "3", Title: "Git Hub Backup", URL: "https://backup.example", Username: "ops", Tags: []string{"home"}},
+			{ID: "4", Title: "Mail", URL: "https://mail.example", Username: "bob", Fields: []vault.CustomField{{Name: "Recovery PIN", Value: "1234"}}},
+		},
+	}
+
+	ids := func(creds []vault.RedactedCredential) []string {
+		result := []string{}
+		for _, cred := range creds {
+			result = append(result, cred.ID)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		opts      SearchOptions
+		wantIDs   []string
+		wantTotal int
+	}{
+		{"Empty Query Keeps Order", SearchOptions{}, []string{"1", "2", "3", "4"}, 4},
+		{"Case Insensitive", SearchOptions{Query: "GITHUB"}, []string{"1", "3"}, 2},
+		{"Fuzzy Ranking", SearchOptions{Query: "gthb"}, []string{"1", "3"}, 2},
+		{"Qualifiers", SearchOptions{Query: "url:github tag:work"}, []string{"1"}, 1},
+		{"Qualifier Excludes Other Fields", SearchOptions{Query: "tag:github"}, []string{}, 0},
+		{"Notes", SearchOptions{Query: "savings"}, []string{"2"}, 1},
+		{"Custom Field Names", SearchOptions{Query: "field:recovery"}, []string{"4"}, 1},
+		{"Sort Descending", SearchOptions{Sort: "-title"}, []string{"4", "1", "3", "2"}, 4},
+		{"Limit And Offset", SearchOptions{Sort: "title", Limit: 2, Offset: 1}, []string{"3", "1"}, 4},
+		{"Offset Past End", SearchOptions{Offset: 10}, []string{}, 4},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, total := app.SearchCredentials(tc.opts)
+			gotIDs := ids(got)
+			if total != tc.wantTotal {
+				t.Errorf("Total mismatch. Got %d, want %d", total, tc.wantTotal)
+			}
+			if len(gotIDs) != len(tc.wantIDs) {
+				t.Fatalf("Result mismatch. Got %v, want %v", gotIDs, tc.wantIDs)
+			}
+			for i := range gotIDs {
+				if gotIDs[i] != tc.wantIDs[i] {
+					t.Fatalf("Result mismatch. Got %v, want %v", gotIDs, tc.wantIDs)
+				}
+			}
+		})
+	}
+}
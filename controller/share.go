@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"PasswordManager/crypto"
+	"PasswordManager/user"
+	"fmt"
+)
+
+// Encrypts data to the public key of username, so only that user can open it from their vault
+func (app *App) SealForUser(username string, data []byte) ([]byte, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	recipient, err := user.GetUser(username)
+	if err != nil {
+		return nil, fmt.Errorf("Could not look up %q. %w", username, err)
+	}
+	if recipient == nil {
+		return nil, fmt.Errorf("User %q does not Exist.", username)
+	}
+	if len(recipient.PublicKey) == 0 {
+		return nil, fmt.Errorf("User %q has no public key yet, they have to sign in once first", username)
+	}
+	return crypto.SealBox(recipient.PublicKey, data)
+}
+
+// Opens a box sealed to the signed in user with SealForUser
+func (app *App) OpenSealedBox(box []byte) ([]byte, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if !app.IsVaultLoaded || app.vaultHeader == nil {
+		return nil, ErrVaultLocked
+	}
+	privateKey, err := app.vaultHeader.OpenPrivateKey(app.key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(privateKey)
+	return crypto.OpenBox(privateKey, box)
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"PasswordManager/user"
+	"PasswordManager/vault"
+	"testing"
+)
+
+func TestSealForUser(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("alice", "Amber-Tundra-Piston-58", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	stored, err := user.GetUser("alice")
+	if err != nil || stored == nil || len(stored.PublicKey) == 0 {
+		t.Fatalf("SignUp should store a public key, got %+v, %v", stored, err)
+	}
+
+	box, err := app.SealForUser("alice", []byte("shared secret"))
+	if err != nil {
+		t.Fatalf("SealForUser failed: %v", err)
+	}
+	if _, err := app.OpenSealedBox(box); err != ErrVaultLocked {
+		t.Errorf("Opening a box needs the vault unlocked, got %v", err)
+	}
+	if _, err := app.SealForUser("nobody", []byte("x")); err == nil {
+		t.Error("SealForUser should fail for an unknown user")
+	}
+
+	if _, err := app.SignIn("alice", "Amber-Tundra-Piston-58"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	opened, err := app.OpenSealedBox(box)
+	if err != nil || string(opened) != "shared secret" {
+		t.Errorf("OpenSealedBox returned %q, %v", opened, err)
+	}
+
+	//The private key survives re-sealing the vault and a master password change
+	if err := app.AddCredential(vault.Credential{URL: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.ChangeMasterPassword("Amber-Tundra-Piston-58", "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Fatal(err)
+	}
+	app.SignOut()
+	if _, err := app.SignIn("alice", "Brisk-Lantern-Fjord-87"); err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := app.OpenSealedBox(box); err != nil || string(opened) != "shared secret" {
+		t.Errorf("OpenSealedBox after password change returned %q, %v", opened, err)
+	}
+}
+
+func TestTwoUsersShareItem(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	for username, password := range map[string]string{"alice": "Amber-Tundra-Piston-58", "bob": "Mossy-Anchor-Quill-19"} {
+		if _, err := app.SignUp(username, password, SignUpOptions{}); err != nil {
+			t.Fatalf("SignUp of %q failed: %v", username, err)
+		}
+	}
+
+	//Signing bob up must leave alice's vault alone
+	if _, err := app.SignIn("alice", "Amber-Tundra-Piston-58"); err != nil {
+		t.Fatalf("SignIn of alice failed: %v", err)
+	}
+	if err := app.AddCredential(vault.Credential{URL: "https://example.com", Username: "team", Password: "shared-pass"}); err != nil {
+		t.Fatal(err)
+	}
+	box, err := app.SealForUser("bob", []byte(app.DecryptedVault[0].Password))
+	if err != nil {
+		t.Fatalf("SealForUser failed: %v", err)
+	}
+	app.SignOut()
+
+	if _, err := app.SignIn("bob", "Mossy-Anchor-Quill-19"); err != nil {
+		t.Fatalf("SignIn of bob failed: %v", err)
+	}
+	if len(app.DecryptedVault) != 0 {
+		t.Errorf("bob should not see alice's items, got %d", len(app.DecryptedVault))
+	}
+	if opened, err := app.OpenSealedBox(box); err != nil || string(opened) != "shared-pass" {
+		t.Errorf("bob could not open the shared item, got %q, %v", opened, err)
+	}
+	app.SignOut()
+
+	if _, err := app.SignIn("alice", "Amber-Tundra-Piston-58"); err != nil {
+		t.Fatalf("SignIn of alice failed: %v", err)
+	}
+	if len(app.DecryptedVault) != 1 {
+		t.Errorf("alice should still have the item, got %d", len(app.DecryptedVault))
+	}
+}
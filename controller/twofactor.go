@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"PasswordManager/audit"
+	"PasswordManager/generator"
+	"PasswordManager/otp"
+	"PasswordManager/user"
+	"PasswordManager/vault"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Issuer shown next to the account in authenticator apps
+const twoFactorIssuer string = "Pharoas"
+
+// Codes of the time steps next to the current one are accepted too, for clock drift
+const totpSkewSteps int = 1
+
+// Backup codes handed out at once, each backupCodeLength characters shown in two halves
+const (
+	backupCodeCount  int = 10
+	backupCodeLength int = 10
+)
+
+var (
+	ErrTwoFactorRequired = errors.New("a two-factor code is required")
+	ErrWrongCode         = errors.New("wrong or already used two-factor code")
+	ErrTwoFactorEnabled  = errors.New("two-factor sign-in is already on")
+	ErrTwoFactorDisabled = errors.New("two-factor sign-in is off")
+	ErrNoEnrollment      = errors.New("no two-factor enrollment in progress")
+)
+
+// Secret of a TOTP authenticator being enrolled, to be put into the user's authenticator app
+type TwoFactorEnrollment struct {
+	URI    string `json:"uri"`
+	Secret string `json:"secret"`
+}
+
+type TwoFactorStatus struct {
+	Enabled         bool `json:"enabled"`
+	BackupCodesLeft int  `json:"backupCodesLeft"`
+}
+
+func (app *App) TwoFactorStatus() TwoFactorStatus {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.CurrentUser == nil {
+		return TwoFactorStatus{}
+	}
+	return TwoFactorStatus{Enabled: app.CurrentUser.HasTwoFactor(), BackupCodesLeft: len(app.CurrentUser.BackupCodes)}
+}
+
+// Starts enrolling a TOTP authenticator for the signed in user. Nothing is stored until
+// ConfirmTwoFactorEnrollment sees a code from it, so a half finished enrollment never locks
+// anyone out.
+func (app *App) BeginTwoFactorEnrollment() (TwoFactorEnrollment, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if !app.IsVaultLoaded {
+		return TwoFactorEnrollment{}, ErrVaultLocked
+	}
+	if app.CurrentUser.HasTwoFactor() {
+		return TwoFactorEnrollment{}, ErrTwoFactorEnabled
+	}
+	key, err := otp.GenerateTOTPKey(twoFactorIssuer, app.CurrentUser.Username)
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	app.pendingTOTP = key
+	return TwoFactorEnrollment{URI: key.URI(), Secret: key.EncodedSecret()}, nil
+}
+
+// Turns two-factor sign-in on once code shows the authenticator from BeginTwoFactorEnrollment
+// works. Returns the backup codes, which are only stored hashed and have to be shown now.
+func (app *App) ConfirmTwoFactorEnrollment(code string) ([]string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	codes, err := app.confirmTwoFactorEnrollment(code, time.Now())
+	if auditErr := app.recordAudit(audit.Entry{Action: audit.ActionEnableTwoFactor}, err); auditErr != nil {
+		return nil, auditErr
+	}
+	return codes, err
+}
+
+func (app *App) confirmTwoFactorEnrollment(code string, now time.Time) ([]string, error) {
+	if !app.IsVaultLoaded {
+		return nil, ErrVaultLocked
+	}
+	if app.pendingTOTP == nil {
+		return nil, ErrNoEnrollment
+	}
+	step, ok := app.pendingTOTP.VerifyTOTP(code, now, totpSkewSteps, 0)
+	if !ok {
+		return nil, ErrWrongCode
+	}
+	codes, digests, err := newBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *app.CurrentUser
+	updated.TOTPSecret = app.pendingTOTP.Secret
+	//The confirming code counts as used
+	updated.TOTPLastStep = step
+	updated.BackupCodes = digests
+	if err := app.saveUser(&updated); err != nil {
+		return nil, err
+	}
+	app.pendingTOTP = nil
+	return codes, nil
+}
+
+// Turns two-factor sign-in off. Asks for the master password again, like other changes to
+// how the vault is unlocked.
+func (app *App) DisableTwoFactor(masterPassword string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	err := app.disableTwoFactor(masterPassword)
+	if auditErr := app.recordAudit(audit.Entry{Action: audit.ActionDisableTwoFactor}, err); auditErr != nil {
+		return auditErr
+	}
+	return err
+}
+
+func (app *App) disableTwoFactor(masterPassword string) error {
+	if err := app.checkMasterPassword(masterPassword); err != nil {
+		return err
+	}
+	if !app.CurrentUser.HasTwoFactor() {
+		return ErrTwoFactorDisabled
+	}
+	updated := *app.CurrentUser
+	updated.TOTPSecret, updated.TOTPLastStep, updated.BackupCodes = nil, 0, nil
+	return app.saveUser(&updated)
+}
+
+// Replaces every backup code with new ones, for when they run low or may have been seen
+func (app *App) RegenerateBackupCodes(masterPassword string) ([]string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	codes, err := app.regenerateBackupCodes(masterPassword)
+	if auditErr := app.recordAudit(audit.Entry{Action: audit.ActionNewBackupCodes}, err); auditErr != nil {
+		return nil, auditErr
+	}
+	return codes, err
+}
+
+func (app *App) regenerateBackupCodes(masterPassword string) ([]string, error) {
+	if err := app.checkMasterPassword(masterPassword); err != nil {
+		return nil, err
+	}
+	if !app.CurrentUser.HasTwoFactor() {
+		return nil, ErrTwoFactorDisabled
+	}
+	codes, digests, err := newBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+	updated := *app.CurrentUser
+	updated.BackupCodes = digests
+	if err := app.saveUser(&updated); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Checks the second factor of the user signing in: a TOTP code of a time step after the last
+// one used, or an unused backup code. Either is used up by saving the user record. Only call
+// this once the password is known to be right.
+func (app *App) checkSecondFactor(code string, now time.Time) error {
+	if code == "" {
+		return ErrTwoFactorRequired
+	}
+	updated := *app.CurrentUser
+	key := &otp.Key{Type: otp.TYPE_TOTP, Secret: updated.TOTPSecret, Algorithm: otp.ALGORITHM_SHA1, Digits: otp.DEFAULT_DIGITS, Period: otp.DEFAULT_PERIOD}
+	if step, ok := key.VerifyTOTP(code, now, totpSkewSteps, updated.TOTPLastStep); ok {
+		updated.TOTPLastStep = step
+		return app.saveUser(&updated)
+	}
+
+	digest := backupCodeDigest(code)
+	for i, stored := range updated.BackupCodes {
+		if subtle.ConstantTimeCompare(digest, stored) == 1 {
+			updated.BackupCodes = append(updated.BackupCodes[:i:i], updated.BackupCodes[i+1:]...)
+			return app.saveUser(&updated)
+		}
+	}
+	return ErrWrongCode
+}
+
+// Writes updated as the current user's record and switches to it
+func (app *App) saveUser(updated *user.User) error {
+	userPath, userData, err := user.PrepareUserUpdate(updated)
+	if err != nil {
+		return err
+	}
+	if err := vault.CommitFiles(map[string][]byte{userPath: userData}); err != nil {
+		return err
+	}
+	app.CurrentUser = updated
+	return nil
+}
+
+// Random backup codes like "k7xq2-mnp4t" and their digests. The codes carry about 50 bits each,
+// too many to guess, so a plain SHA-256 is enough to keep them out of the user file.
+func newBackupCodes() ([]string, [][]byte, error) {
+	opts := generator.Options{Length: backupCodeLength, Lowercase: true, Digits: true, ExcludeAmbiguous: true}
+	codes := make([]string, backupCodeCount)
+	digests := make([][]byte, backupCodeCount)
+	for i := range codes {
+		code, err := generator.Generate(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = code[:backupCodeLength/2] + "-" + code[backupCodeLength/2:]
+		digests[i] = backupCodeDigest(code)
+	}
+	return codes, digests, nil
+}
+
+// Digest of a backup code, ignoring case, spaces and the dash in the middle
+func backupCodeDigest(code string) []byte {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	digest := sha256.Sum256([]byte(normalized))
+	return digest[:]
+}
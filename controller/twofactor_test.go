@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"PasswordManager/otp"
+	"PasswordManager/user"
+	"PasswordManager/vault"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTwoFactorSignIn(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("alice", "Pale-Orbit-Kettle-42", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if _, err := app.SignIn("alice", "Pale-Orbit-Kettle-42"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+
+	if _, err := app.ConfirmTwoFactorEnrollment("123456"); !errors.Is(err, ErrNoEnrollment) {
+		t.Errorf("Confirming without an enrollment should fail, got %v", err)
+	}
+	enrollment, err := app.BeginTwoFactorEnrollment()
+	if err != nil {
+		t.Fatalf("BeginTwoFactorEnrollment failed: %v", err)
+	}
+	key, err := otp.ParseURI(enrollment.URI)
+	if err != nil || key.Account != "alice" || key.EncodedSecret() != enrollment.Secret {
+		t.Fatalf("Unexpected enrollment %+v, %v", enrollment, err)
+	}
+	codeAt := func(offset time.Duration) string {
+		code, _ := key.TOTP(time.Now().Add(offset))
+		return code
+	}
+	if _, err := app.ConfirmTwoFactorEnrollment(codeAt(-time.Hour)); !errors.Is(err, ErrWrongCode) {
+		t.Errorf("A wrong code should not turn two-factor sign-in on, got %v", err)
+	}
+	backupCodes, err := app.ConfirmTwoFactorEnrollment(codeAt(0))
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactorEnrollment failed: %v", err)
+	}
+	if status := app.TwoFactorStatus(); !status.Enabled || status.BackupCodesLeft != 10 || len(backupCodes) != 10 {
+		t.Fatalf("Expected two-factor sign-in with 10 backup codes, got %+v and %v", status, backupCodes)
+	}
+	stored, _ := user.GetUser("alice")
+	if !stored.HasTwoFactor() {
+		t.Fatal("The TOTP secret should be stored with the user")
+	}
+	for _, digest := range stored.BackupCodes {
+		if string(digest) == backupCodes[0] {
+			t.Error("Backup codes must only be stored hashed")
+		}
+	}
+	app.SignOut()
+
+	if _, err := app.SignIn("alice", "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("A wrong password should still be reported as such, got %v", err)
+	}
+	if _, err := app.SignIn("alice", "Pale-Orbit-Kettle-42"); !errors.Is(err, ErrTwoFactorRequired) || app.IsVaultLoaded {
+		t.Fatalf("Signing in without a code should fail, got %v", err)
+	}
+	//The confirming code was used up
+	if _, err := app.SignInWithCode("alice", "Pale-Orbit-Kettle-42", nil, codeAt(0)); !errors.Is(err, ErrWrongCode) {
+		t.Errorf("The enrollment code should not work again, got %v", err)
+	}
+
+	next := codeAt(30 * time.Second)
+	if _, err := app.SignInWithCode("alice", "Pale-Orbit-Kettle-42", nil, next); err != nil || !app.IsVaultLoaded {
+		t.Fatalf("SignInWithCode failed: %v", err)
+	}
+	app.SignOut()
+	if _, err := app.SignInWithCode("alice", "Pale-Orbit-Kettle-42", nil, next); !errors.Is(err, ErrWrongCode) {
+		t.Errorf("A code must not be accepted twice, got %v", err)
+	}
+
+	//Backup codes work once each, typed any way
+	if _, err := app.SignInWithCode("alice", "Pale-Orbit-Kettle-42", nil, " "+backupCodes[3][:5]+backupCodes[3][6:]+" "); err != nil {
+		t.Fatalf("Signing in with a backup code failed: %v", err)
+	}
+	if status := app.TwoFactorStatus(); status.BackupCodesLeft != 9 {
+		t.Errorf("The backup code should be used up, got %+v", status)
+	}
+	app.SignOut()
+	if _, err := app.SignInWithCode("alice", "Pale-Orbit-Kettle-42", nil, backupCodes[3]); !errors.Is(err, ErrWrongCode) {
+		t.Errorf("A backup code must not be accepted twice, got %v", err)
+	}
+	if _, err := app.SignInWithCode("alice", "Pale-Orbit-Kettle-42", nil, backupCodes[4]); err != nil {
+		t.Fatalf("Signing in with a backup code failed: %v", err)
+	}
+
+	newCodes, err := app.RegenerateBackupCodes("Pale-Orbit-Kettle-42")
+	if err != nil || len(newCodes) != 10 || app.TwoFactorStatus().BackupCodesLeft != 10 {
+		t.Errorf("RegenerateBackupCodes failed: %v, %v", newCodes, err)
+	}
+	if err := app.DisableTwoFactor("wrong"); !errors.Is(err, ErrWrongReprompt) {
+		t.Errorf("Turning two-factor sign-in off needs the master password, got %v", err)
+	}
+	if err := app.DisableTwoFactor("Pale-Orbit-Kettle-42"); err != nil {
+		t.Fatalf("DisableTwoFactor failed: %v", err)
+	}
+	app.SignOut()
+	if _, err := app.SignIn("alice", "Pale-Orbit-Kettle-42"); err != nil {
+		t.Errorf("Signing in should only need the password again, got %v", err)
+	}
+}
+
+func TestWrongPasswordKeepsCode(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("bob", "Mossy-Anchor-Quill-19", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if _, err := app.SignIn("bob", "Mossy-Anchor-Quill-19"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	enrollment, err := app.BeginTwoFactorEnrollment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := otp.ParseURI(enrollment.URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := key.TOTP(time.Now())
+	backupCodes, err := app.ConfirmTwoFactorEnrollment(code)
+	if err != nil {
+		t.Fatalf("ConfirmTwoFactorEnrollment failed: %v", err)
+	}
+	//An account from before key-check values, where only opening the vault proves the password
+	legacy := *app.CurrentUser
+	legacy.KeyCheck = nil
+	if err := app.saveUser(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	app.SignOut()
+
+	if _, err := app.SignInWithCode("bob", "wrong", nil, backupCodes[0]); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("A wrong password should give ErrWrongPassword, got %v", err)
+	}
+	if stored, _ := user.GetUser("bob"); len(stored.BackupCodes) != 10 {
+		t.Errorf("A wrong password must not use up a backup code, %d left", len(stored.BackupCodes))
+	}
+	if _, err := app.SignInWithCode("bob", "Mossy-Anchor-Quill-19", nil, backupCodes[0]); err != nil {
+		t.Errorf("The backup code should still work, got %v", err)
+	}
+}
+
+func TestWrongCodeLeavesVaultUnopened(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	app := NewApp()
+	app.KDFPolicy = testKDFPolicy
+	if _, err := app.SignUp("carol", "Velvet-Comet-Harbor-63", SignUpOptions{}); err != nil {
+		t.Fatalf("SignUp failed: %v", err)
+	}
+	if _, err := app.SignIn("carol", "Velvet-Comet-Harbor-63"); err != nil {
+		t.Fatalf("SignIn failed: %v", err)
+	}
+	enrollment, err := app.BeginTwoFactorEnrollment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := otp.ParseURI(enrollment.URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := key.TOTP(time.Now())
+	if _, err := app.ConfirmTwoFactorEnrollment(code); err != nil {
+		t.Fatalf("ConfirmTwoFactorEnrollment failed: %v", err)
+	}
+	app.SignOut()
+
+	//Without its vault file a sign-in only gets as far as the code check if it never reads it
+	vaultPath, err := vault.GetVaultPath("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(vaultPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.SignIn("carol", "Velvet-Comet-Harbor-63"); !errors.Is(err, ErrTwoFactorRequired) {
+		t.Errorf("A missing code should be reported before the vault is read, got %v", err)
+	}
+	stale, _ := key.TOTP(time.Now().Add(-time.Hour))
+	if _, err := app.SignInWithCode("carol", "Velvet-Comet-Harbor-63", nil, stale); !errors.Is(err, ErrWrongCode) {
+		t.Errorf("A wrong code should be reported before the vault is read, got %v", err)
+	}
+	if app.IsVaultLoaded || app.DecryptedVault != nil {
+		t.Error("A wrong code must leave the vault unopened")
+	}
+}
@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Size of X25519 public and private keys
+const BOX_KEY_LEN int = 32
+
+// Generates an X25519 key pair and returns the public and the private key
+func GenerateKeyPair() ([]byte, []byte, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not generate a key pair: %w", err)
+	}
+	return privateKey.PublicKey().Bytes(), privateKey.Bytes(), nil
+}
+
+// Encrypts data so only the holder of the private key for recipientPublicKey can open it. A
+// fresh ephemeral key pair is agreed with the recipient's key, so the sender needs no key of
+// its own and cannot open the box afterwards. The box is the ephemeral public key followed by
+// the nonce and XChaCha20-Poly1305 ciphertext.
+func SealBox(recipientPublicKey []byte, data []byte) ([]byte, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(recipientPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid recipient public key: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Could not seal box: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("Key agreement failed: %w", err)
+	}
+	ephemeralPublicKey := ephemeral.PublicKey().Bytes()
+	key, err := boxKey(shared, ephemeralPublicKey, recipientPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(key)
+
+	nonce, cipherText, err := XChaCha20Poly1305.Encrypt(key, data)
+	if err != nil {
+		return nil, err
+	}
+	box := make([]byte, 0, len(ephemeralPublicKey)+len(nonce)+len(cipherText))
+	box = append(box, ephemeralPublicKey...)
+	box = append(box, nonce...)
+	return append(box, cipherText...), nil
+}
+
+// Opens a box made by SealBox with the recipient's private key
+func OpenBox(privateKey []byte, box []byte) ([]byte, error) {
+	self, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid private key: %w", err)
+	}
+	nonceSize := XChaCha20Poly1305.NonceSize()
+	if len(box) < BOX_KEY_LEN+nonceSize {
+		return nil, fmt.Errorf("Sealed box is truncated")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(box[:BOX_KEY_LEN])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sealed box: %w", err)
+	}
+	shared, err := self.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("Key agreement failed: %w", err)
+	}
+	key, err := boxKey(shared, box[:BOX_KEY_LEN], self.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(key)
+	return XChaCha20Poly1305.Decrypt(key, box[BOX_KEY_LEN:BOX_KEY_LEN+nonceSize], box[BOX_KEY_LEN+nonceSize:])
+}
+
+// Derives the box key from the X25519 shared secret, bound to both public keys, and wipes the
+// shared secret
+func boxKey(shared []byte, ephemeralPublicKey []byte, recipientPublicKey []byte) ([]byte, error) {
+	defer Wipe(shared)
+	salt := append(append([]byte{}, ephemeralPublicKey...), recipientPublicKey...)
+	key, err := hkdf.Key(sha256.New, shared, salt, "sealed box", KEY_LEN)
+	if err != nil {
+		return nil, fmt.Errorf("Could not derive box key: %w", err)
+	}
+	return key, nil
+}
@@ -3,9 +3,13 @@ package crypto
 import (
 	"bytes"
 	"crypto/aes"
+	"crypto/hkdf"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"strings"
 	"testing"
+	"time"
 )
 
 // TestGetDerivedKey tests the GetDerivedKey function.
@@ -82,6 +86,67 @@ func TestGetDerivedKey(t *testing.T) {
 	}
 }
 
+// TestDeriveKey tests DeriveKey against known answers for each supported KDF.
+func TestDeriveKey(t *testing.T) {
+	// --- Test Case 1: Argon2id reference vector (phc-winner-argon2, v=19, m=65536, t=2, p=1) ---
+	t.Run("Argon2id Known Answer", func(t *testing.T) {
+		params := KDFParams{Algorithm: KDF_ARGON2ID, Memory: 65536, Time: 2, Parallelism: 1}
+		expectedKey, _ := hex.DecodeString("09316115d5cf24ed5a15a31a3ba326e5cf32edc24702987c02b6566f61913cf7")
+
+		derivedKey, err := DeriveKey([]byte("password"), []byte("somesalt"), params)
+		if err != nil {
+			t.Fatalf("DeriveKey failed: %v", err)
+		}
+		if !bytes.Equal(derivedKey, expectedKey) {
+			t.Errorf("Argon2id key mismatch.\nGot:  %x\nWant: %x", derivedKey, expectedKey)
+		}
+	})
+
+	// --- Test Case 2: PBKDF2 parameters go through GetDerivedKey ---
+	t.Run("PBKDF2 Known Answer", func(t *testing.T) {
+		params := KDFParams{Algorithm: KDF_PBKDF2, Iterations: 4096}
+		expectedKey, _ := hex.DecodeString("c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a")
+
+		derivedKey, err := DeriveKey([]byte("password"), []byte("salt"), params)
+		if err != nil {
+			t.Fatalf("DeriveKey failed: %v", err)
+		}
+		if !bytes.Equal(derivedKey, expectedKey) {
+			t.Errorf("PBKDF2 key mismatch.\nGot:  %x\nWant: %x", derivedKey, expectedKey)
+		}
+	})
+
+	// --- Test Case 3: Defaults are valid and legacy settings match the old hard-coded ones ---
+	t.Run("Default And Legacy Params", func(t *testing.T) {
+		if err := DefaultKDFParams().Validate(); err != nil {
+			t.Errorf("Default KDF params are invalid: %v", err)
+		}
+		if DefaultKDFParams().Algorithm != KDF_ARGON2ID {
+			t.Errorf("Default KDF should be Argon2id, got %q", DefaultKDFParams().Algorithm)
+		}
+		legacy := LegacyKDFParams()
+		if legacy.Algorithm != KDF_PBKDF2 || legacy.Iterations != 100096 {
+			t.Errorf("Legacy KDF params changed: %+v", legacy)
+		}
+	})
+
+	// --- Test Case 4: Invalid parameters are rejected ---
+	t.Run("Invalid Params", func(t *testing.T) {
+		invalid := []KDFParams{
+			{},
+			{Algorithm: "scrypt"},
+			{Algorithm: KDF_PBKDF2},
+			{Algorithm: KDF_ARGON2ID, Memory: 65536, Time: 0, Parallelism: 1},
+			{Algorithm: KDF_ARGON2ID, Memory: 16, Time: 1, Parallelism: 4},
+		}
+		for _, params := range invalid {
+			if _, err := DeriveKey([]byte("password"), []byte("salt"), params); err == nil {
+				t.Errorf("DeriveKey should reject %+v", params)
+			}
+		}
+	})
+}
+
 // Testing encryption and Decryption functions
 func TestEncryptDecrypt(t *testing.T) {
 	// A fixed test key (32 bytes for AES-256). In production, this would be derived.
@@ -296,3 +361,377 @@ func BenchmarkDecrypt(b *testing.B) {
 		}
 	}
 }
+
+// Testing recovery key formatting and parsing
+func TestRecoveryKey(t *testing.T) {
+	key, formatted, err := GenerateRecoveryKey()
+	if err != nil {
+		t.Fatalf("GenerateRecoveryKey failed: %v", err)
+	}
+	if len(key) != KEY_LEN {
+		t.Errorf("Recovery key length mismatch. Got %d, want %d", len(key), KEY_LEN)
+	}
+
+	// --- Parsing is tolerant of case, spaces and dashes ---
+	inputs := []string{
+		formatted,
+		formatted + "\n",
+		strings.ToLower(formatted),
+		strings.ReplaceAll(formatted, "-", " "),
+	}
+	for _, input := range inputs {
+		parsed, err := ParseRecoveryKey(input)
+		if err != nil {
+			t.Fatalf("ParseRecoveryKey(%q) failed: %v", input, err)
+		}
+		if !bytes.Equal(parsed, key) {
+			t.Errorf("ParseRecoveryKey(%q) returned a different key", input)
+		}
+	}
+
+	// --- Known encoding ---
+	zero := make([]byte, KEY_LEN)
+	if got := FormatRecoveryKey(zero); got != "AAAA-AAAA-AAAA-AAAA-AAAA-AAAA-AAAA-AAAA-AAAA-AAAA-AAAA-AAAA-AAAA" {
+		t.Errorf("FormatRecoveryKey(zero) = %q", got)
+	}
+
+	// --- Invalid input ---
+	for _, input := range []string{"", "not a key!", "AAAA-AAAA"} {
+		if _, err := ParseRecoveryKey(input); err == nil {
+			t.Errorf("ParseRecoveryKey(%q) should have failed", input)
+		}
+	}
+}
+
+// Testing the key-check value used to tell a wrong password from a damaged vault
+func TestKeyCheckValue(t *testing.T) {
+	key := []byte("thisisatestmasterencryptionkey32")
+	otherKey := []byte("thisisanotherencryptionkey32byte")
+
+	check, err := KeyCheckValue(key)
+	if err != nil {
+		t.Fatalf("KeyCheckValue failed: %v", err)
+	}
+	if len(check) != 32 {
+		t.Errorf("Key-check length mismatch. Got %d, want 32", len(check))
+	}
+	if bytes.Contains(check, key) {
+		t.Error("Key-check value must not contain the key")
+	}
+
+	if ok, err := VerifyKeyCheck(key, check); err != nil || !ok {
+		t.Errorf("VerifyKeyCheck should accept the right key, got %v, %v", ok, err)
+	}
+	if ok, err := VerifyKeyCheck(otherKey, check); err != nil || ok {
+		t.Errorf("VerifyKeyCheck should reject a different key, got %v, %v", ok, err)
+	}
+}
+
+// Known-answer tests for each cipher suite, from the AES-GCM spec (test case 16) and
+// draft-irtf-cfrg-xchacha (appendix A.3.1)
+func TestCipherSuites(t *testing.T) {
+	vectors := []struct {
+		suite      CipherSuite
+		key        string
+		nonce      string
+		aad        string
+		plaintext  string
+		cipherText string
+	}{
+		{
+			suite:      AES256GCM,
+			key:        "feffe9928665731c6d6a8f9467308308feffe9928665731c6d6a8f9467308308",
+			nonce:      "cafebabefacedbaddecaf888",
+			aad:        "feedfacedeadbeeffeedfacedeadbeefabaddad2",
+			plaintext:  "d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39",
+			cipherText: "522dc1f099567d07f47f37a32a84427d643a8cdcbfe5c0c97598a2bd2555d1aa8cb08e48590dbb3da7b08b1056828838c5f61e6393ba7a0abcc9f662" + "76fc6ece0f4e1768cddf8853bb2d551b",
+		},
+		{
+			suite:      XChaCha20Poly1305,
+			key:        "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+			nonce:      "404142434445464748494a4b4c4d4e4f5051525354555657",
+			aad:        "50515253c0c1c2c3c4c5c6c7",
+			plaintext:  hex.EncodeToString([]byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")),
+			cipherText: "bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b4522f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff921f9664c97637da9768812f615c68b13b52e" + "c0875924c1c7987947deafd8780acf49",
+		},
+	}
+
+	for _, v := range vectors {
+		t.Run(v.suite.Name(), func(t *testing.T) {
+			key, _ := hex.DecodeString(v.key)
+			nonce, _ := hex.DecodeString(v.nonce)
+			aad, _ := hex.DecodeString(v.aad)
+			plaintext, _ := hex.DecodeString(v.plaintext)
+			want, _ := hex.DecodeString(v.cipherText)
+
+			aead, err := v.suite.(aeadSuite).newAEAD(key)
+			if err != nil {
+				t.Fatalf("Could not create AEAD: %v", err)
+			}
+			if got := aead.Seal(nil, nonce, plaintext, aad); !bytes.Equal(got, want) {
+				t.Errorf("Ciphertext mismatch.\nGot:  %x\nWant: %x", got, want)
+			}
+
+			//Round trip through the suite's own random-nonce API
+			nonce, cipherText, err := v.suite.Encrypt(key, plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt failed: %v", err)
+			}
+			if len(nonce) != v.suite.NonceSize() {
+				t.Errorf("Nonce length mismatch. Got %d, want %d", len(nonce), v.suite.NonceSize())
+			}
+			decrypted, err := v.suite.Decrypt(key, nonce, cipherText)
+			if err != nil || !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("Decrypt returned %x, %v", decrypted, err)
+			}
+			cipherText[0] ^= 0x01
+			if _, err := v.suite.Decrypt(key, nonce, cipherText); err == nil {
+				t.Error("Decrypt should reject a tampered ciphertext")
+			}
+		})
+	}
+
+	for _, name := range []string{"", SUITE_AES256_GCM, SUITE_XCHACHA20_POLY1305} {
+		if _, err := GetCipherSuite(name); err != nil {
+			t.Errorf("GetCipherSuite(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := GetCipherSuite("rot13"); err == nil {
+		t.Error("GetCipherSuite should reject an unknown suite")
+	}
+}
+
+// Testing the locked secret buffers that hold keys and vault plaintext
+func TestSecretBuffer(t *testing.T) {
+	src := []byte("a secret that must move")
+	buf, err := NewSecretBufferFrom(src)
+	if err != nil {
+		t.Fatalf("NewSecretBufferFrom failed: %v", err)
+	}
+	if string(buf.Bytes()) != "a secret that must move" {
+		t.Errorf("Secret buffer holds %q", buf.Bytes())
+	}
+	if !bytes.Equal(src, make([]byte, len(src))) {
+		t.Error("NewSecretBufferFrom should wipe the source")
+	}
+	if cap(buf.Bytes()) != buf.Len() {
+		t.Errorf("Secret buffer capacity %d should equal its length %d", cap(buf.Bytes()), buf.Len())
+	}
+	buf.Destroy()
+	buf.Destroy()
+	if buf.Bytes() != nil || buf.Len() != 0 || buf.Locked() {
+		t.Error("A destroyed buffer should hold nothing")
+	}
+
+	empty, err := NewSecretBuffer(0)
+	if err != nil || empty.Len() != 0 {
+		t.Errorf("NewSecretBuffer(0) returned %v, %v", empty, err)
+	}
+	if _, err := NewSecretBuffer(-1); err == nil {
+		t.Error("NewSecretBuffer should reject a negative size")
+	}
+	var nilBuffer *SecretBuffer
+	nilBuffer.Destroy()
+
+	//Vault plaintext is opened straight into a secret buffer
+	key := []byte("thisisatestmasterencryptionkey32")
+	plaintext := []byte(`[{"password":"hunter2"}]`)
+	for _, suite := range []CipherSuite{AES256GCM, XChaCha20Poly1305} {
+		nonce, cipherText, err := suite.Encrypt(key, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opened, err := suite.DecryptSecret(key, nonce, cipherText)
+		if err != nil || !bytes.Equal(opened.Bytes(), plaintext) {
+			t.Errorf("%s: DecryptSecret returned %q, %v", suite.Name(), opened.Bytes(), err)
+		}
+		opened.Destroy()
+		if _, err := suite.DecryptSecret(key, nonce, cipherText[:4]); err == nil {
+			t.Errorf("%s: DecryptSecret should reject a truncated ciphertext", suite.Name())
+		}
+	}
+}
+
+// Testing the keyfile factor of the composite master key
+func TestKeyfile(t *testing.T) {
+	keyfile, err := GenerateKeyfile()
+	if err != nil || len(keyfile) != KEYFILE_LEN {
+		t.Fatalf("GenerateKeyfile returned %d bytes, %v", len(keyfile), err)
+	}
+	digest, err := KeyfileDigest(keyfile)
+	if err != nil || len(digest) != KEY_LEN {
+		t.Fatalf("KeyfileDigest returned %d bytes, %v", len(digest), err)
+	}
+	again, _ := KeyfileDigest(keyfile)
+	if !bytes.Equal(digest, again) {
+		t.Error("KeyfileDigest should be deterministic")
+	}
+	if _, err := KeyfileDigest(nil); err == nil {
+		t.Error("KeyfileDigest should reject an empty keyfile")
+	}
+
+	passwordKey := []byte("thisisatestmasterencryptionkey32")
+	combined, err := CombineKeyfile(passwordKey, digest)
+	if err != nil || len(combined) != KEY_LEN {
+		t.Fatalf("CombineKeyfile returned %d bytes, %v", len(combined), err)
+	}
+	if bytes.Equal(combined, passwordKey) || bytes.Equal(combined, digest) {
+		t.Error("The composite key must differ from either factor")
+	}
+	otherDigest, _ := KeyfileDigest([]byte("some other file"))
+	otherCombined, _ := CombineKeyfile(passwordKey, otherDigest)
+	if bytes.Equal(combined, otherCombined) {
+		t.Error("A different keyfile must give a different composite key")
+	}
+}
+
+// Testing Shamir secret sharing over GF(256)
+func TestShamir(t *testing.T) {
+	//Inverse pair from the AES specification
+	if gfMul(0x53, 0xca) != 0x01 || gfInverse(0x53) != 0xca {
+		t.Errorf("GF(256) arithmetic is wrong: 0x53*0xca = %#x, 1/0x53 = %#x", gfMul(0x53, 0xca), gfInverse(0x53))
+	}
+	for a := 1; a < 256; a++ {
+		if gfMul(byte(a), gfInverse(byte(a))) != 1 {
+			t.Fatalf("%#x times its inverse is not 1", a)
+		}
+	}
+
+	secret := []byte("thisisatestmasterencryptionkey32")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil || len(shares) != 5 {
+		t.Fatalf("SplitSecret returned %d shares, %v", len(shares), err)
+	}
+
+	//Every choice of 3 shares rebuilds the secret
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				combined, err := CombineShares([][]byte{shares[k], shares[i], shares[j]})
+				if err != nil || !bytes.Equal(combined, secret) {
+					t.Errorf("Shares %d, %d, %d gave %q, %v", i, j, k, combined, err)
+				}
+			}
+		}
+	}
+	if combined, _ := CombineShares(shares[:2]); bytes.Equal(combined, secret) {
+		t.Error("Fewer shares than the threshold must not rebuild the secret")
+	}
+	if _, err := CombineShares([][]byte{shares[0], shares[0], shares[1]}); err == nil {
+		t.Error("CombineShares should reject a repeated share")
+	}
+
+	for _, counts := range [][2]int{{3, 1}, {2, 3}, {256, 3}} {
+		if _, err := SplitSecret(secret, counts[0], counts[1]); err == nil {
+			t.Errorf("SplitSecret should reject %d of %d", counts[1], counts[0])
+		}
+	}
+
+	formatted := FormatShare(shares[0])
+	parsed, err := ParseShare(strings.ToLower(formatted))
+	if err != nil || !bytes.Equal(parsed, shares[0]) {
+		t.Errorf("Share did not survive formatting: %v", err)
+	}
+	if _, err := ParseShare("not a share!"); err == nil {
+		t.Error("ParseShare should reject garbage")
+	}
+}
+
+// Testing the HKDF key schedule of purpose-specific subkeys
+func TestDeriveSubkey(t *testing.T) {
+	root := []byte("thisisatestmasterencryptionkey32")
+	purposes := []string{PURPOSE_VAULT_ENCRYPTION, PURPOSE_INDEX_MAC, PURPOSE_KEY_CHECK, PURPOSE_EXPORT, PURPOSE_PRIVATE_KEY, PURPOSE_KEY_WRAP}
+
+	seen := map[string]string{}
+	for _, purpose := range purposes {
+		subkey, err := DeriveSubkey(root, purpose)
+		if err != nil || len(subkey) != KEY_LEN {
+			t.Fatalf("DeriveSubkey(%s) returned %d bytes, %v", purpose, len(subkey), err)
+		}
+		if bytes.Equal(subkey, root) {
+			t.Errorf("The %s subkey must differ from the root key", purpose)
+		}
+		if other, ok := seen[hex.EncodeToString(subkey)]; ok {
+			t.Errorf("Purposes %s and %s share a subkey", purpose, other)
+		}
+		seen[hex.EncodeToString(subkey)] = purpose
+		again, _ := DeriveSubkey(root, purpose)
+		if !bytes.Equal(subkey, again) {
+			t.Errorf("The %s subkey should be deterministic", purpose)
+		}
+	}
+
+	//Stored key-check values were made with this exact label
+	want, _ := hkdf.Key(sha256.New, root, nil, "key-check", KEY_LEN)
+	if got, _ := DeriveSubkey(root, PURPOSE_KEY_CHECK); !bytes.Equal(got, want) {
+		t.Error("The key-check label changed, stored key-check values would no longer match")
+	}
+
+	if _, err := DeriveSubkey(root, "everything"); err == nil {
+		t.Error("DeriveSubkey should reject an unknown purpose")
+	}
+	if _, err := DeriveSubkey(root[:16], PURPOSE_EXPORT); err == nil {
+		t.Error("DeriveSubkey should reject a short root key")
+	}
+}
+
+// Testing the start-up self-tests and KDF calibration
+func TestSelfTestAndCalibration(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatalf("SelfTest failed: %v", err)
+	}
+
+	params, err := CalibrateKDF(time.Nanosecond)
+	if err != nil {
+		t.Fatalf("CalibrateKDF failed: %v", err)
+	}
+	if err := params.Validate(); err != nil || params.Algorithm != KDF_ARGON2ID {
+		t.Errorf("CalibrateKDF picked invalid parameters %+v: %v", params, err)
+	}
+	if params.Time != MIN_ARGON2_TIME || params.Memory != DefaultKDFParams().Memory {
+		t.Errorf("A tiny target should give the minimum passes at default memory, got %+v", params)
+	}
+	if params, _ := CalibrateKDF(time.Hour); params.Time != MAX_ARGON2_TIME {
+		t.Errorf("A huge target should be capped at %d passes, got %d", MAX_ARGON2_TIME, params.Time)
+	}
+	if _, err := CalibrateKDF(0); err == nil {
+		t.Error("CalibrateKDF should reject a zero target")
+	}
+}
+
+// Testing X25519 sealed boxes
+func TestSealedBox(t *testing.T) {
+	publicKey, privateKey, err := GenerateKeyPair()
+	if err != nil || len(publicKey) != BOX_KEY_LEN || len(privateKey) != BOX_KEY_LEN {
+		t.Fatalf("GenerateKeyPair returned %d and %d bytes, %v", len(publicKey), len(privateKey), err)
+	}
+	_, otherPrivateKey, _ := GenerateKeyPair()
+
+	box, err := SealBox(publicKey, []byte("for your eyes only"))
+	if err != nil {
+		t.Fatalf("SealBox failed: %v", err)
+	}
+	opened, err := OpenBox(privateKey, box)
+	if err != nil || string(opened) != "for your eyes only" {
+		t.Errorf("OpenBox returned %q, %v", opened, err)
+	}
+	again, _ := SealBox(publicKey, []byte("for your eyes only"))
+	if bytes.Equal(box, again) {
+		t.Error("Each box should use a fresh ephemeral key")
+	}
+
+	if _, err := OpenBox(otherPrivateKey, box); err == nil {
+		t.Error("OpenBox should fail with another private key")
+	}
+	tampered := append([]byte{}, box...)
+	tampered[len(tampered)-1] ^= 0x01
+	if _, err := OpenBox(privateKey, tampered); err == nil {
+		t.Error("OpenBox should reject a tampered box")
+	}
+	if _, err := OpenBox(privateKey, box[:BOX_KEY_LEN]); err == nil {
+		t.Error("OpenBox should reject a truncated box")
+	}
+	if _, err := SealBox(publicKey[:16], []byte("x")); err == nil {
+		t.Error("SealBox should reject an invalid public key")
+	}
+}
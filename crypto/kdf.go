@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Supported key derivation functions
+const (
+	KDF_PBKDF2   string = "pbkdf2-sha256"
+	KDF_ARGON2ID string = "argon2id"
+)
+
+// PBKDF2 iterations used by accounts created before KDF parameters were stored
+const LEGACY_PBKDF2_ITERATIONS int = 100096
+
+// Bounds on the Argon2id passes CalibrateKDF picks, however slow or fast the machine
+const (
+	MIN_ARGON2_TIME uint32 = 2
+	MAX_ARGON2_TIME uint32 = 100
+)
+
+// Parameters of the function that turns a master password into the Master Encryption Key.
+// Only the fields of the selected Algorithm are used.
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	//PBKDF2 iteration count
+	Iterations int `json:"iterations,omitempty"`
+	//Argon2id memory in KiB
+	Memory uint32 `json:"memory,omitempty"`
+	//Argon2id passes over the memory
+	Time uint32 `json:"time,omitempty"`
+	//Argon2id lanes
+	Parallelism uint8 `json:"parallelism,omitempty"`
+}
+
+// Argon2id parameters used for new accounts: 64 MiB, 3 passes, 4 lanes
+func DefaultKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm:   KDF_ARGON2ID,
+		Memory:      64 * 1024,
+		Time:        3,
+		Parallelism: 4,
+	}
+}
+
+// PBKDF2 parameters that accounts without stored parameters were created with
+func LegacyKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm:  KDF_PBKDF2,
+		Iterations: LEGACY_PBKDF2_ITERATIONS,
+	}
+}
+
+// Checks that the parameters name a known algorithm and are within sane bounds
+func (params KDFParams) Validate() error {
+	switch params.Algorithm {
+	case KDF_PBKDF2:
+		if params.Iterations < 1 {
+			return fmt.Errorf("Invalid KDF parameters: PBKDF2 needs at least 1 iteration")
+		}
+	case KDF_ARGON2ID:
+		if params.Time < 1 || params.Parallelism < 1 {
+			return fmt.Errorf("Invalid KDF parameters: Argon2id needs time and parallelism of at least 1")
+		}
+		if params.Memory < 8*uint32(params.Parallelism) {
+			return fmt.Errorf("Invalid KDF parameters: Argon2id needs at least %d KiB of memory", 8*uint32(params.Parallelism))
+		}
+	default:
+		return fmt.Errorf("Invalid KDF parameters: unknown algorithm %q", params.Algorithm)
+	}
+	return nil
+}
+
+// Reports whether params fall short of policy, either by using a different algorithm or by
+// using less work than the policy asks for
+func (params KDFParams) WeakerThan(policy KDFParams) bool {
+	if params.Algorithm != policy.Algorithm {
+		return true
+	}
+	if params.Algorithm == KDF_PBKDF2 {
+		return params.Iterations < policy.Iterations
+	}
+	return params.Memory < policy.Memory || params.Time < policy.Time || params.Parallelism < policy.Parallelism
+}
+
+// Derives a KEY_LEN byte key from masterPassword and salt with the given parameters
+func DeriveKey(masterPassword []byte, salt []byte, params KDFParams) ([]byte, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if params.Algorithm == KDF_ARGON2ID {
+		return argon2.IDKey(masterPassword, salt, params.Time, params.Memory, params.Parallelism, uint32(KEY_LEN)), nil
+	}
+	return GetDerivedKey(masterPassword, salt, params.Iterations), nil
+}
+
+// Benchmarks Argon2id at the default memory and parallelism and picks the number of passes
+// for which deriving a key takes about target on this machine
+func CalibrateKDF(target time.Duration) (KDFParams, error) {
+	if target <= 0 {
+		return KDFParams{}, fmt.Errorf("KDF calibration target must be positive, got %v", target)
+	}
+	params := DefaultKDFParams()
+	params.Time = 1
+	password := []byte("calibration password")
+	salt := make([]byte, KEY_LEN)
+
+	//Best of a few single-pass runs, the first one also pays for faulting in the memory
+	var perPass time.Duration
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		if _, err := DeriveKey(password, salt, params); err != nil {
+			return KDFParams{}, err
+		}
+		if elapsed := time.Since(start); i == 0 || elapsed < perPass {
+			perPass = elapsed
+		}
+	}
+
+	passes := uint64(target / max(perPass, time.Microsecond))
+	params.Time = uint32(min(max(passes, uint64(MIN_ARGON2_TIME)), uint64(MAX_ARGON2_TIME)))
+	return params, nil
+}
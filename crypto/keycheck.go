@@ -0,0 +1,32 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Fixed message authenticated by the key-check value
+const keyCheckLabel string = "PasswordManager key check v1"
+
+// Computes the key-check value stored with a user: an HMAC-SHA256 of a fixed label under a
+// subkey derived from the master key. It tells a wrong password apart from a damaged vault
+// without revealing anything about the master key itself.
+func KeyCheckValue(masterKey []byte) ([]byte, error) {
+	subkey, err := DeriveSubkey(masterKey, PURPOSE_KEY_CHECK)
+	if err != nil {
+		return nil, err
+	}
+	defer Wipe(subkey)
+	mac := hmac.New(sha256.New, subkey)
+	mac.Write([]byte(keyCheckLabel))
+	return mac.Sum(nil), nil
+}
+
+// Reports in constant time whether masterKey produces the stored key-check value
+func VerifyKeyCheck(masterKey []byte, expected []byte) (bool, error) {
+	actual, err := KeyCheckValue(masterKey)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(actual, expected), nil
+}
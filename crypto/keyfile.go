@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// Size of generated keyfiles. Files chosen by the user may have any non-zero size.
+const KEYFILE_LEN int = 64
+
+// Fixed HMAC key condensing keyfile contents into a digest
+const keyfileLabel string = "PasswordManager keyfile v1"
+
+// Generates the contents of a new random keyfile
+func GenerateKeyfile() ([]byte, error) {
+	contents := make([]byte, KEYFILE_LEN)
+	if _, err := io.ReadFull(rand.Reader, contents); err != nil {
+		return nil, fmt.Errorf("Could not generate a keyfile: %w", err)
+	}
+	return contents, nil
+}
+
+// Condenses keyfile contents of any size into a KEY_LEN digest with HMAC-SHA256
+func KeyfileDigest(contents []byte) ([]byte, error) {
+	if len(contents) == 0 {
+		return nil, fmt.Errorf("Keyfile is empty")
+	}
+	mac := hmac.New(sha256.New, []byte(keyfileLabel))
+	mac.Write(contents)
+	return mac.Sum(nil), nil
+}
+
+// Combines the password-derived key and a keyfile digest into the composite master key with
+// HKDF-SHA256, so neither factor alone is enough to unlock the vault
+func CombineKeyfile(passwordKey []byte, keyfileDigest []byte) ([]byte, error) {
+	secret := make([]byte, 0, len(passwordKey)+len(keyfileDigest))
+	secret = append(append(secret, passwordKey...), keyfileDigest...)
+	defer Wipe(secret)
+	key, err := hkdf.Key(sha256.New, secret, nil, "composite master key", KEY_LEN)
+	if err != nil {
+		return nil, fmt.Errorf("Could not combine keyfile: %w", err)
+	}
+	return key, nil
+}
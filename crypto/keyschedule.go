@@ -0,0 +1,36 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Purposes a key is used for. Each gets its own subkey, derived with the purpose as HKDF info
+// label, so a subkey leaked or misused for one purpose says nothing about the others. The
+// labels are part of the stored data format and must never change.
+const (
+	PURPOSE_VAULT_ENCRYPTION string = "vault-encryption"
+	PURPOSE_INDEX_MAC        string = "index-mac"
+	PURPOSE_KEY_CHECK        string = "key-check"
+	PURPOSE_EXPORT           string = "export"
+	PURPOSE_PRIVATE_KEY      string = "private-key"
+	PURPOSE_KEY_WRAP         string = "key-wrap"
+)
+
+// Derives the KEY_LEN byte subkey of rootKey for purpose with HKDF-SHA256
+func DeriveSubkey(rootKey []byte, purpose string) ([]byte, error) {
+	switch purpose {
+	case PURPOSE_VAULT_ENCRYPTION, PURPOSE_INDEX_MAC, PURPOSE_KEY_CHECK, PURPOSE_EXPORT, PURPOSE_PRIVATE_KEY, PURPOSE_KEY_WRAP:
+	default:
+		return nil, fmt.Errorf("Unknown key purpose %q", purpose)
+	}
+	if len(rootKey) < KEY_LEN {
+		return nil, fmt.Errorf("Root key must be at least %d bytes, got %d", KEY_LEN, len(rootKey))
+	}
+	subkey, err := hkdf.Key(sha256.New, rootKey, nil, purpose, KEY_LEN)
+	if err != nil {
+		return nil, fmt.Errorf("Could not derive %s subkey: %w", purpose, err)
+	}
+	return subkey, nil
+}
@@ -0,0 +1,63 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Characters per group when a recovery key is shown to the user
+const RECOVERY_GROUP_LEN int = 4
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generates a random KEY_LEN byte recovery key and its printable form, base32 in dash
+// separated groups. The key is used directly as a wrapping key so no KDF is needed.
+func GenerateRecoveryKey() ([]byte, string, error) {
+	key := make([]byte, KEY_LEN)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, "", fmt.Errorf("Could not generate a recovery key: %w", err)
+	}
+	return key, FormatRecoveryKey(key), nil
+}
+
+// Encodes a recovery key as grouped base32, e.g. ABCD-EFGH-...
+func FormatRecoveryKey(key []byte) string {
+	return formatGrouped(key)
+}
+
+// Decodes a recovery key typed by the user. Case, dashes and whitespace are ignored.
+func ParseRecoveryKey(formatted string) ([]byte, error) {
+	key, err := parseGrouped(formatted)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid recovery key: %w", err)
+	}
+	if len(key) != KEY_LEN {
+		return nil, fmt.Errorf("Invalid recovery key: expected %d bytes, got %d", KEY_LEN, len(key))
+	}
+	return key, nil
+}
+
+func formatGrouped(data []byte) string {
+	encoded := recoveryEncoding.EncodeToString(data)
+	groups := make([]string, 0, len(encoded)/RECOVERY_GROUP_LEN+1)
+	for len(encoded) > RECOVERY_GROUP_LEN {
+		groups = append(groups, encoded[:RECOVERY_GROUP_LEN])
+		encoded = encoded[RECOVERY_GROUP_LEN:]
+	}
+	groups = append(groups, encoded)
+	return strings.Join(groups, "-")
+}
+
+func parseGrouped(formatted string) ([]byte, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(formatted))
+
+	return recoveryEncoding.DecodeString(cleaned)
+}
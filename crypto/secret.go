@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Holds key material outside the Go heap where the platform allows it. On Linux the bytes are
+// mlock'd so they are never swapped out, excluded from core dumps and surrounded by
+// inaccessible guard pages. Destroy overwrites them with zeros.
+type SecretBuffer struct {
+	data []byte
+	//Whole allocation including guard pages, nil when the bytes live on the Go heap
+	mapping []byte
+	locked  bool
+}
+
+// Allocates a zeroed buffer of size bytes
+func NewSecretBuffer(size int) (*SecretBuffer, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("Invalid secret buffer size %d", size)
+	}
+	buf := &SecretBuffer{}
+	if size == 0 {
+		buf.data = []byte{}
+		return buf, nil
+	}
+	var err error
+	buf.data, buf.mapping, buf.locked, err = allocSecret(size)
+	if err != nil {
+		return nil, fmt.Errorf("Could not allocate secret buffer: %w", err)
+	}
+	return buf, nil
+}
+
+// Moves src into a new buffer and wipes src, so the only copy left is the protected one
+func NewSecretBufferFrom(src []byte) (*SecretBuffer, error) {
+	buf, err := NewSecretBuffer(len(src))
+	if err != nil {
+		Wipe(src)
+		return nil, err
+	}
+	copy(buf.data, src)
+	Wipe(src)
+	return buf, nil
+}
+
+// The secret bytes. They must not be used after Destroy.
+func (buf *SecretBuffer) Bytes() []byte {
+	if buf == nil {
+		return nil
+	}
+	return buf.data
+}
+
+func (buf *SecretBuffer) Len() int {
+	if buf == nil {
+		return 0
+	}
+	return len(buf.data)
+}
+
+// Reports whether the bytes are locked into RAM. Locking is best effort: it fails when the
+// memlock limit is reached, and the buffer then works like a wiped heap allocation.
+func (buf *SecretBuffer) Locked() bool {
+	return buf != nil && buf.locked
+}
+
+// Zeroes and releases the buffer. It is safe to call more than once and on nil.
+func (buf *SecretBuffer) Destroy() {
+	if buf == nil || buf.data == nil {
+		return
+	}
+	Wipe(buf.data)
+	if buf.mapping != nil {
+		freeSecret(buf.mapping, buf.locked)
+	}
+	buf.data = nil
+	buf.mapping = nil
+	buf.locked = false
+}
+
+// Overwrites b with zeros
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	//Keep the stores from being optimised away
+	runtime.KeepAlive(b)
+}
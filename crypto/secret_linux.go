@@ -0,0 +1,42 @@
+//go:build linux
+
+package crypto
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Maps size bytes of anonymous memory between two PROT_NONE guard pages. The secret is placed
+// at the end of its pages so an overrun faults on the guard page right away.
+func allocSecret(size int) ([]byte, []byte, bool, error) {
+	pageSize := unix.Getpagesize()
+	inner := (size + pageSize - 1) / pageSize * pageSize
+	mapping, err := unix.Mmap(-1, 0, inner+2*pageSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if err := unix.Mprotect(mapping[:pageSize], unix.PROT_NONE); err != nil {
+		unix.Munmap(mapping)
+		return nil, nil, false, err
+	}
+	if err := unix.Mprotect(mapping[pageSize+inner:], unix.PROT_NONE); err != nil {
+		unix.Munmap(mapping)
+		return nil, nil, false, err
+	}
+
+	pages := mapping[pageSize : pageSize+inner]
+	//Keep the secret out of core dumps, then out of swap when the memlock limit allows it
+	unix.Madvise(pages, unix.MADV_DONTDUMP)
+	locked := unix.Mlock(pages) == nil
+
+	end := pageSize + inner
+	return mapping[end-size : end : end], mapping, locked, nil
+}
+
+func freeSecret(mapping []byte, locked bool) {
+	pageSize := unix.Getpagesize()
+	if locked {
+		unix.Munlock(mapping[pageSize : len(mapping)-pageSize])
+	}
+	unix.Munmap(mapping)
+}
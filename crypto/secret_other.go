@@ -0,0 +1,10 @@
+//go:build !linux
+
+package crypto
+
+// Without mlock support secrets live on the Go heap and are only protected by being wiped
+func allocSecret(size int) ([]byte, []byte, bool, error) {
+	return make([]byte, size), nil, false, nil
+}
+
+func freeSecret(mapping []byte, locked bool) {}
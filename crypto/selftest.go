@@ -0,0 +1,147 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// A known-answer test of one primitive
+type selfTest struct {
+	name string
+	run  func() error
+}
+
+// Runs known-answer tests of every primitive the vault depends on and returns the first
+// failure. The server must not handle secrets when this fails.
+func SelfTest() error {
+	for _, test := range selfTests {
+		if err := test.run(); err != nil {
+			return fmt.Errorf("Self-test %s failed: %w", test.name, err)
+		}
+	}
+	return nil
+}
+
+var selfTests = []selfTest{
+	{"AES-256-GCM", func() error {
+		//McGrew and Viega, test case 16
+		return checkAEAD(AES256GCM,
+			"feffe9928665731c6d6a8f9467308308feffe9928665731c6d6a8f9467308308",
+			"cafebabefacedbaddecaf888",
+			"feedfacedeadbeeffeedfacedeadbeefabaddad2",
+			"d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a721c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b39",
+			"522dc1f099567d07f47f37a32a84427d643a8cdcbfe5c0c97598a2bd2555d1aa8cb08e48590dbb3da7b08b1056828838c5f61e6393ba7a0abcc9f662"+
+				"76fc6ece0f4e1768cddf8853bb2d551b")
+	}},
+	{"XChaCha20-Poly1305", func() error {
+		//draft-irtf-cfrg-xchacha, appendix A.3.1
+		return checkAEAD(XChaCha20Poly1305,
+			"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+			"404142434445464748494a4b4c4d4e4f5051525354555657",
+			"50515253c0c1c2c3c4c5c6c7",
+			hex.EncodeToString([]byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")),
+			"bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b4522f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff921f9664c97637da9768812f615c68b13b52e"+
+				"c0875924c1c7987947deafd8780acf49")
+	}},
+	{"PBKDF2-SHA256", func() error {
+		//RFC 7914 section 11, first 32 bytes
+		params := KDFParams{Algorithm: KDF_PBKDF2, Iterations: 1}
+		return checkKDF(params, "passwd", "salt", "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc")
+	}},
+	{"Argon2id", func() error {
+		//phc-winner-argon2 reference, v=19
+		params := KDFParams{Algorithm: KDF_ARGON2ID, Memory: 65536, Time: 2, Parallelism: 1}
+		return checkKDF(params, "password", "somesalt", "09316115d5cf24ed5a15a31a3ba326e5cf32edc24702987c02b6566f61913cf7")
+	}},
+	{"HKDF-SHA256", func() error {
+		//RFC 5869, test case 1
+		secret, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+		salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+		info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+		okm, err := hkdf.Key(sha256.New, secret, salt, string(info), 42)
+		if err != nil {
+			return err
+		}
+		return checkEqual(okm, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+	}},
+	{"X25519", func() error {
+		//RFC 7748 section 6.1
+		alice, _ := hex.DecodeString("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+		bobPublic, _ := hex.DecodeString("de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f")
+		privateKey, err := ecdh.X25519().NewPrivateKey(alice)
+		if err != nil {
+			return err
+		}
+		if err := checkEqual(privateKey.PublicKey().Bytes(), "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"); err != nil {
+			return err
+		}
+		publicKey, err := ecdh.X25519().NewPublicKey(bobPublic)
+		if err != nil {
+			return err
+		}
+		shared, err := privateKey.ECDH(publicKey)
+		if err != nil {
+			return err
+		}
+		return checkEqual(shared, "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742")
+	}},
+	{"GF(256)", func() error {
+		//Inverse pair from the AES specification
+		if gfMul(0x53, 0xca) != 0x01 || gfInverse(0x53) != 0xca {
+			return fmt.Errorf("0x53 and 0xca are not inverses")
+		}
+		shares, err := SplitSecret([]byte("self-test secret"), 3, 2)
+		if err != nil {
+			return err
+		}
+		combined, err := CombineShares(shares[1:])
+		if err != nil {
+			return err
+		}
+		if string(combined) != "self-test secret" {
+			return fmt.Errorf("shares did not rebuild the secret")
+		}
+		return nil
+	}},
+}
+
+// Checks that suite seals plaintext to the expected ciphertext and opens it again
+func checkAEAD(suite CipherSuite, keyHex string, nonceHex string, aadHex string, plaintextHex string, cipherTextHex string) error {
+	key, _ := hex.DecodeString(keyHex)
+	nonce, _ := hex.DecodeString(nonceHex)
+	aad, _ := hex.DecodeString(aadHex)
+	plaintext, _ := hex.DecodeString(plaintextHex)
+	aead, err := suite.(aeadSuite).newAEAD(key)
+	if err != nil {
+		return err
+	}
+	cipherText := aead.Seal(nil, nonce, plaintext, aad)
+	if err := checkEqual(cipherText, cipherTextHex); err != nil {
+		return err
+	}
+	opened, err := aead.Open(nil, nonce, cipherText, aad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		return fmt.Errorf("ciphertext did not open to the plaintext")
+	}
+	return nil
+}
+
+func checkKDF(params KDFParams, password string, salt string, expectedHex string) error {
+	key, err := DeriveKey([]byte(password), []byte(salt), params)
+	if err != nil {
+		return err
+	}
+	return checkEqual(key, expectedHex)
+}
+
+func checkEqual(got []byte, expectedHex string) error {
+	expected, _ := hex.DecodeString(expectedHex)
+	if !bytes.Equal(got, expected) {
+		return fmt.Errorf("got %x, want %x", got, expected)
+	}
+	return nil
+}
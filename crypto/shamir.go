@@ -0,0 +1,123 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// Most shares a secret can be split into: each share needs its own non-zero x in GF(256)
+const MAX_SHARES int = 255
+
+// Splits secret with Shamir's scheme over GF(256) into total shares of which any threshold
+// rebuild it, while fewer reveal nothing about it. Each share is its x coordinate followed by
+// one y byte per secret byte.
+func SplitSecret(secret []byte, total int, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("Cannot split an empty secret")
+	}
+	if threshold < 2 || threshold > total || total > MAX_SHARES {
+		return nil, fmt.Errorf("Invalid share counts: need 2 <= threshold (%d) <= total (%d) <= %d", threshold, total, MAX_SHARES)
+	}
+
+	shares := make([][]byte, total)
+	for i := range shares {
+		shares[i] = make([]byte, 1+len(secret))
+		shares[i][0] = byte(i + 1)
+	}
+	//One random polynomial per secret byte, with the byte as its constant term
+	coefficients := make([]byte, threshold)
+	defer Wipe(coefficients)
+	for b, value := range secret {
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("Could not split secret: %w", err)
+		}
+		coefficients[0] = value
+		for _, share := range shares {
+			share[1+b] = evaluatePolynomial(coefficients, share[0])
+		}
+	}
+	return shares, nil
+}
+
+// Rebuilds a secret from shares made by SplitSecret. Given fewer shares than the threshold it
+// returns a wrong secret rather than an error, so the result has to be checked by its use,
+// e.g. by unwrapping an authenticated key slot.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("At least 2 shares are needed")
+	}
+	size := len(shares[0])
+	seen := map[byte]bool{}
+	for _, share := range shares {
+		if len(share) != size || size < 2 {
+			return nil, fmt.Errorf("Shares have different or invalid lengths")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, fmt.Errorf("Share %d is invalid or given twice", share[0])
+		}
+		seen[share[0]] = true
+	}
+
+	//Lagrange interpolation at x = 0. Subtraction is XOR in GF(256).
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = gfMul(basis, gfMul(other[0], gfInverse(other[0]^share[0])))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(share[1+b], basis)
+		}
+	}
+	return secret, nil
+}
+
+// Encodes a share for printing, in the same grouped base32 as recovery keys
+func FormatShare(share []byte) string {
+	return formatGrouped(share)
+}
+
+// Decodes a share typed by the user. Case, dashes and whitespace are ignored.
+func ParseShare(formatted string) ([]byte, error) {
+	share, err := parseGrouped(formatted)
+	if err != nil || len(share) < 2 || share[0] == 0 {
+		return nil, fmt.Errorf("Invalid recovery share")
+	}
+	return share, nil
+}
+
+// Horner evaluation of the polynomial with the given coefficients, lowest degree first
+func evaluatePolynomial(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coefficients[i]
+	}
+	return result
+}
+
+// Multiplication in GF(256) modulo the AES polynomial x^8 + x^4 + x^3 + x + 1, without
+// branches or table lookups that depend on secret values
+func gfMul(a byte, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		product ^= -(b & 1) & a
+		a = (a << 1) ^ (-(a >> 7) & 0x1b)
+		b >>= 1
+	}
+	return product
+}
+
+// Inverse in GF(256) as a^254, zero for zero
+func gfInverse(a byte) byte {
+	result := byte(1)
+	for exponent := 254; exponent > 0; exponent >>= 1 {
+		if exponent&1 == 1 {
+			result = gfMul(result, a)
+		}
+		a = gfMul(a, a)
+	}
+	return result
+}
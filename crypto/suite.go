@@ -0,0 +1,131 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Names of the supported cipher suites, recorded next to every ciphertext they produce
+const (
+	SUITE_AES256_GCM         string = "aes-256-gcm"
+	SUITE_XCHACHA20_POLY1305 string = "xchacha20-poly1305"
+)
+
+// An AEAD cipher with random nonces. Encrypt returns the nonce separately so callers decide
+// how to store it next to the cipherText.
+type CipherSuite interface {
+	Name() string
+	NonceSize() int
+	Encrypt(key []byte, data []byte) ([]byte, []byte, error)
+	Decrypt(key []byte, nonce []byte, cipherText []byte) ([]byte, error)
+	//Like Decrypt, but the plaintext is written straight into a SecretBuffer
+	DecryptSecret(key []byte, nonce []byte, cipherText []byte) (*SecretBuffer, error)
+}
+
+// AES256-GCM with 96-bit random nonces. Fast with AES-NI, but a key should not seal more
+// than about 2^32 messages before random nonces risk colliding.
+var AES256GCM CipherSuite = aeadSuite{name: SUITE_AES256_GCM, nonceSize: NONCE_LEN, newAEAD: newAESGCM}
+
+// XChaCha20-Poly1305 with 192-bit random nonces, which are safe to pick at random for any
+// practical number of messages. Fast in software on CPUs without AES instructions.
+var XChaCha20Poly1305 CipherSuite = aeadSuite{name: SUITE_XCHACHA20_POLY1305, nonceSize: chacha20poly1305.NonceSizeX, newAEAD: chacha20poly1305.NewX}
+
+// Suite used for everything newly sealed
+var DefaultCipherSuite CipherSuite = XChaCha20Poly1305
+
+// Looks up a suite by its recorded name. Ciphertexts written before suites were recorded
+// have no name and are AES256-GCM.
+func GetCipherSuite(name string) (CipherSuite, error) {
+	switch name {
+	case "", SUITE_AES256_GCM:
+		return AES256GCM, nil
+	case SUITE_XCHACHA20_POLY1305:
+		return XChaCha20Poly1305, nil
+	}
+	return nil, fmt.Errorf("Unknown cipher suite %q", name)
+}
+
+type aeadSuite struct {
+	name      string
+	nonceSize int
+	newAEAD   func(key []byte) (cipher.AEAD, error)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	//Get the cipher.Block interface to be used in GCM
+	cipherBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	//GCM Mode of Operation for AES gives us the AREAD
+	return cipher.NewGCM(cipherBlock)
+}
+
+func (suite aeadSuite) Name() string {
+	return suite.name
+}
+
+func (suite aeadSuite) NonceSize() int {
+	return suite.nonceSize
+}
+
+// Seals data under key with a fresh random nonce
+func (suite aeadSuite) Encrypt(key []byte, data []byte) ([]byte, []byte, error) {
+	aead, err := suite.newAEAD(key)
+	if err != nil {
+		return nil, nil, errors.New("Encryption Failed: " + err.Error())
+	}
+	//Generating a CyrptoGraphically Secure Psuedo Random nonce
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, errors.New("Encryption Failed: " + err.Error())
+	}
+	return nonce, aead.Seal(nil, nonce, data, nil), nil
+}
+
+// Opens cipherText sealed by Encrypt under key with nonce
+func (suite aeadSuite) Decrypt(key []byte, nonce []byte, cipherText []byte) ([]byte, error) {
+	aead, err := suite.newAEAD(key)
+	if err != nil {
+		return nil, errors.New("Decryption Failed: " + err.Error())
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("Decryption Failed: nonce must be %d bytes, got %d", aead.NonceSize(), len(nonce))
+	}
+	decryptedData, err := aead.Open(nil, nonce, cipherText, nil)
+	if err != nil {
+		return nil, errors.New("Decryption and/or Authentication Failed: " + err.Error())
+	}
+	return decryptedData, nil
+}
+
+// Opens cipherText in place inside a SecretBuffer so the plaintext bytes never touch the Go
+// heap. Anything the caller parses out of them is ordinary memory again.
+func (suite aeadSuite) DecryptSecret(key []byte, nonce []byte, cipherText []byte) (*SecretBuffer, error) {
+	aead, err := suite.newAEAD(key)
+	if err != nil {
+		return nil, errors.New("Decryption Failed: " + err.Error())
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("Decryption Failed: nonce must be %d bytes, got %d", aead.NonceSize(), len(nonce))
+	}
+	if len(cipherText) < aead.Overhead() {
+		return nil, errors.New("Decryption and/or Authentication Failed: ciphertext is too short")
+	}
+	plain, err := NewSecretBuffer(len(cipherText) - aead.Overhead())
+	if err != nil {
+		return nil, err
+	}
+	//The buffer has exactly the capacity Open needs, so it fills it instead of reallocating
+	if _, err := aead.Open(plain.Bytes()[:0], nonce, cipherText, nil); err != nil {
+		plain.Destroy()
+		return nil, errors.New("Decryption and/or Authentication Failed: " + err.Error())
+	}
+	return plain, nil
+}
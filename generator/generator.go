@@ -0,0 +1,238 @@
+package generator
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Bounds on the length of generated passwords
+const (
+	MIN_LENGTH int = 4
+	MAX_LENGTH int = 256
+)
+
+// Character classes a password can draw from
+const (
+	LOWERCASE string = "abcdefghijklmnopqrstuvwxyz"
+	UPPERCASE string = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	DIGITS    string = "0123456789"
+	SYMBOLS   string = "!#$%&()*+,-./:;<=>?@[]^_{|}~"
+)
+
+// Characters easily confused with one another when read or typed
+const AMBIGUOUS string = "0O1lI|"
+
+// Most attempts at a password that keeps to a max-consecutive limit
+const maxAttempts int = 100
+
+// What a generated password looks like. A class with a minimum above zero is enabled even
+// when its flag is not set.
+type Options struct {
+	Length       int  `json:"length"`
+	Lowercase    bool `json:"lowercase"`
+	Uppercase    bool `json:"uppercase"`
+	Digits       bool `json:"digits"`
+	Symbols      bool `json:"symbols"`
+	MinLowercase int  `json:"minLowercase,omitempty"`
+	MinUppercase int  `json:"minUppercase,omitempty"`
+	MinDigits    int  `json:"minDigits,omitempty"`
+	MinSymbols   int  `json:"minSymbols,omitempty"`
+	//Characters never to use
+	Exclude          string `json:"exclude,omitempty"`
+	ExcludeAmbiguous bool   `json:"excludeAmbiguous,omitempty"`
+	//Use every character at most once
+	NoRepeat bool `json:"noRepeat,omitempty"`
+}
+
+// 20 characters from all four classes, at least one of each
+func DefaultOptions() Options {
+	return Options{
+		Length:       20,
+		Lowercase:    true,
+		Uppercase:    true,
+		Digits:       true,
+		Symbols:      true,
+		MinLowercase: 1,
+		MinUppercase: 1,
+		MinDigits:    1,
+		MinSymbols:   1,
+	}
+}
+
+// One enabled character class with the characters left after exclusions
+type class struct {
+	name    string
+	chars   []rune
+	minimum int
+}
+
+// Generates a random password from crypto/rand. Every character is drawn uniformly from the
+// characters still allowed, so no character is more likely than another. The minimum of each
+// class is drawn from that class first, then the rest from all enabled classes, and the result
+// is shuffled so the required characters do not sit at fixed positions.
+func Generate(opts Options) (string, error) {
+	classes, err := opts.classes()
+	if err != nil {
+		return "", err
+	}
+	var all []rune
+	for _, c := range classes {
+		all = append(all, c.chars...)
+	}
+	return generate(classes, all, opts.Length, opts.NoRepeat, 0)
+}
+
+// Draws length characters: the minimum of each class from the class, the rest from all, then
+// shuffles them. A result with a run of more than maxConsecutive equal characters is thrown
+// away and drawn again, which keeps the remaining results equally likely.
+func generate(classes []class, all []rune, length int, noRepeat bool, maxConsecutive int) (string, error) {
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		password, err := draw(classes, all, length, noRepeat)
+		if err != nil {
+			return "", err
+		}
+		if maxConsecutive == 0 || longestRun(password) <= maxConsecutive {
+			return string(password), nil
+		}
+	}
+	return "", fmt.Errorf("Could not keep to at most %d equal characters in a row", maxConsecutive)
+}
+
+func draw(classes []class, all []rune, length int, noRepeat bool) ([]rune, error) {
+	password := make([]rune, 0, length)
+	used := map[rune]bool{}
+	pick := func(chars []rune) error {
+		if noRepeat {
+			chars = unused(chars, used)
+		}
+		if len(chars) == 0 {
+			return fmt.Errorf("Too few characters are allowed to fill the password without repeats")
+		}
+		i, err := randomIndex(len(chars))
+		if err != nil {
+			return err
+		}
+		used[chars[i]] = true
+		password = append(password, chars[i])
+		return nil
+	}
+
+	for _, c := range classes {
+		for i := 0; i < c.minimum; i++ {
+			if err := pick(c.chars); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for len(password) < length {
+		if err := pick(all); err != nil {
+			return nil, err
+		}
+	}
+
+	//Fisher-Yates
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomIndex(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return password, nil
+}
+
+// Length of the longest run of one character
+func longestRun(password []rune) int {
+	longest, run := 0, 0
+	for i := range password {
+		if i > 0 && password[i] == password[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+	return longest
+}
+
+// Checks the options and returns the enabled classes with exclusions applied
+func (opts Options) classes() ([]class, error) {
+	if opts.Length < MIN_LENGTH || opts.Length > MAX_LENGTH {
+		return nil, fmt.Errorf("Length must be between %d and %d", MIN_LENGTH, MAX_LENGTH)
+	}
+	excluded := opts.Exclude
+	if opts.ExcludeAmbiguous {
+		excluded += AMBIGUOUS
+	}
+
+	candidates := []struct {
+		name    string
+		chars   string
+		enabled bool
+		minimum int
+	}{
+		{"lowercase", LOWERCASE, opts.Lowercase, opts.MinLowercase},
+		{"uppercase", UPPERCASE, opts.Uppercase, opts.MinUppercase},
+		{"digits", DIGITS, opts.Digits, opts.MinDigits},
+		{"symbols", SYMBOLS, opts.Symbols, opts.MinSymbols},
+	}
+	var classes []class
+	minimums, available := 0, 0
+	for _, candidate := range candidates {
+		if candidate.minimum < 0 {
+			return nil, fmt.Errorf("Minimum of %s cannot be negative", candidate.name)
+		}
+		if !candidate.enabled && candidate.minimum == 0 {
+			continue
+		}
+		var chars []rune
+		for _, char := range candidate.chars {
+			if !strings.ContainsRune(excluded, char) {
+				chars = append(chars, char)
+			}
+		}
+		if len(chars) == 0 {
+			return nil, fmt.Errorf("Every character of %s is excluded", candidate.name)
+		}
+		if opts.NoRepeat && candidate.minimum > len(chars) {
+			return nil, fmt.Errorf("Only %d %s are left, fewer than the minimum of %d without repeats", len(chars), candidate.name, candidate.minimum)
+		}
+		minimums += candidate.minimum
+		available += len(chars)
+		classes = append(classes, class{name: candidate.name, chars: chars, minimum: candidate.minimum})
+	}
+
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("At least one character class must be enabled")
+	}
+	if minimums > opts.Length {
+		return nil, fmt.Errorf("Minimums add up to %d, more than the length of %d", minimums, opts.Length)
+	}
+	if opts.NoRepeat && available < opts.Length {
+		return nil, fmt.Errorf("Only %d characters are allowed, too few for %d without repeats", available, opts.Length)
+	}
+	return classes, nil
+}
+
+// Characters of chars not in used
+func unused(chars []rune, used map[rune]bool) []rune {
+	var left []rune
+	for _, char := range chars {
+		if !used[char] {
+			left = append(left, char)
+		}
+	}
+	return left
+}
+
+// Uniform random index below n. crypto/rand.Int rejects out of range samples instead of
+// reducing them modulo n, which would favour the low indexes.
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("Could not read random bytes: %w", err)
+	}
+	return int(i.Int64()), nil
+}
@@ -0,0 +1,275 @@
+package generator
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func countIn(password string, chars string) int {
+	count := 0
+	for _, char := range password {
+		if strings.ContainsRune(chars, char) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestGenerate(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		for i := 0; i < 50; i++ {
+			password, err := Generate(DefaultOptions())
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			if len(password) != 20 {
+				t.Fatalf("Expected 20 characters, got %d", len(password))
+			}
+			for _, chars := range []string{LOWERCASE, UPPERCASE, DIGITS, SYMBOLS} {
+				if countIn(password, chars) == 0 {
+					t.Errorf("%q has none of %q", password, chars)
+				}
+			}
+		}
+	})
+
+	t.Run("Minimums", func(t *testing.T) {
+		opts := Options{Length: 12, Lowercase: true, MinDigits: 5, MinSymbols: 3}
+		password, err := Generate(opts)
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if countIn(password, DIGITS) < 5 || countIn(password, SYMBOLS) < 3 {
+			t.Errorf("%q misses its minimums", password)
+		}
+		if countIn(password, UPPERCASE) != 0 {
+			t.Errorf("%q uses a disabled class", password)
+		}
+	})
+
+	t.Run("Exclusions", func(t *testing.T) {
+		opts := DefaultOptions()
+		opts.Length = 200
+		opts.Exclude = "abc#"
+		opts.ExcludeAmbiguous = true
+		password, err := Generate(opts)
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		if countIn(password, "abc#"+AMBIGUOUS) != 0 {
+			t.Errorf("%q uses an excluded character", password)
+		}
+	})
+
+	t.Run("No repeats", func(t *testing.T) {
+		opts := Options{Length: 10, Digits: true, MinDigits: 10, NoRepeat: true}
+		password, err := Generate(opts)
+		if err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		}
+		for _, digit := range DIGITS {
+			if strings.Count(password, string(digit)) != 1 {
+				t.Errorf("%q does not use every digit exactly once", password)
+			}
+		}
+	})
+
+	t.Run("Uniform", func(t *testing.T) {
+		//Each of 10 digits over 20000 draws is expected 2000 times; 1700 is far outside chance
+		counts := map[rune]int{}
+		for i := 0; i < 1000; i++ {
+			password, err := Generate(Options{Length: 20, Digits: true})
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+			for _, char := range password {
+				counts[char]++
+			}
+		}
+		for _, digit := range DIGITS {
+			if counts[digit] < 1700 || counts[digit] > 2300 {
+				t.Errorf("Digit %c was drawn %d times out of 20000", digit, counts[digit])
+			}
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		invalid := map[string]Options{
+			"too short":             {Length: 2, Lowercase: true},
+			"too long":              {Length: MAX_LENGTH + 1, Lowercase: true},
+			"no classes":            {Length: 10},
+			"minimums too large":    {Length: 4, MinDigits: 3, MinSymbols: 2},
+			"class excluded":        {Length: 10, Digits: true, Exclude: DIGITS},
+			"too few to not repeat": {Length: 11, Digits: true, NoRepeat: true},
+			"negative minimum":      {Length: 10, Lowercase: true, MinDigits: -1},
+		}
+		for name, opts := range invalid {
+			if _, err := Generate(opts); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+	})
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	t.Run("Wordlists", func(t *testing.T) {
+		for name, size := range map[string]int{WORDLIST_LARGE: 7776, WORDLIST_SHORT: 1296} {
+			list := wordlists[name]()
+			if len(list) != size {
+				t.Errorf("%s has %d words, expected %d", name, len(list), size)
+			}
+			for _, word := range list {
+				if word == "" || strings.ContainsAny(word, " \t\r") {
+					t.Fatalf("%s has a malformed word %q", name, word)
+				}
+			}
+		}
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		result, err := GeneratePassphrase(DefaultPassphraseOptions())
+		if err != nil {
+			t.Fatalf("GeneratePassphrase failed: %v", err)
+		}
+		if words := strings.Split(result.Passphrase, "-"); len(words) < 6 {
+			t.Errorf("Expected 6 words in %q", result.Passphrase)
+		}
+		if result.Entropy < 77 || result.Entropy > 78 {
+			t.Errorf("Expected about 77.5 bits, got %f", result.Entropy)
+		}
+	})
+
+	t.Run("Options", func(t *testing.T) {
+		opts := PassphraseOptions{Words: 4, Wordlist: WORDLIST_SHORT, Separator: " ", Capitalize: CAPITALIZE_WORDS, Digits: 2}
+		result, err := GeneratePassphrase(opts)
+		if err != nil {
+			t.Fatalf("GeneratePassphrase failed: %v", err)
+		}
+		words := strings.Split(result.Passphrase, " ")
+		if len(words) != 4 {
+			t.Fatalf("Expected 4 words in %q", result.Passphrase)
+		}
+		for _, word := range words {
+			if word[0] < 'A' || word[0] > 'Z' {
+				t.Errorf("%q is not capitalized", word)
+			}
+		}
+		if countIn(result.Passphrase, DIGITS) != 2 {
+			t.Errorf("Expected 2 digits in %q", result.Passphrase)
+		}
+		//4 * log2(1296) + 2 * log2(10)
+		if result.Entropy < 48 || result.Entropy > 48.1 {
+			t.Errorf("Expected about 48 bits, got %f", result.Entropy)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		invalid := map[string]PassphraseOptions{
+			"too few words":  {Words: 2},
+			"too many words": {Words: MAX_WORDS + 1},
+			"wordlist":       {Words: 6, Wordlist: "klingon"},
+			"capitalize":     {Words: 6, Capitalize: "shout"},
+			"separator":      {Words: 6, Separator: "----------"},
+			"digits":         {Words: 6, Digits: 7},
+		}
+		for name, opts := range invalid {
+			if _, err := GeneratePassphrase(opts); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+	})
+}
+
+func TestPasswordRules(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		policy, err := ParsePasswordRules("minlength: 8; maxlength: 16; required: digit; required: upper, lower; allowed: [-_]; max-consecutive: 2; future-rule: 1;")
+		if err != nil {
+			t.Fatalf("ParsePasswordRules failed: %v", err)
+		}
+		if policy.MinLength != 8 || policy.MaxLength != 16 || policy.MaxConsecutive != 2 {
+			t.Errorf("Wrong bounds in %+v", policy)
+		}
+		if len(policy.Required) != 2 || policy.Required[0] != DIGITS || policy.Required[1] != UPPERCASE+LOWERCASE {
+			t.Errorf("Wrong required sets %q", policy.Required)
+		}
+		if policy.Allowed != "-_" {
+			t.Errorf("Expected allowed -_, got %q", policy.Allowed)
+		}
+
+		policy, err = ParsePasswordRules("required: [;]], ] ")
+		if err == nil {
+			t.Errorf("Expected an error for a stray bracket, got %+v", policy)
+		}
+		policy, err = ParsePasswordRules("allowed: []-;]")
+		if err != nil || policy.Allowed != "-;]" {
+			t.Errorf("Expected allowed -;], got %q (%v)", policy.Allowed, err)
+		}
+		policy, err = ParsePasswordRules("")
+		if err != nil || policy.Allowed != RULES_ASCII_PRINTABLE {
+			t.Errorf("Empty rules should allow all printable ASCII, got %q (%v)", policy.Allowed, err)
+		}
+		for _, invalid := range []string{"minlength: eight", "maxlength: 4; minlength: 8", "required: emoji", "required"} {
+			if _, err := ParsePasswordRules(invalid); err == nil {
+				t.Errorf("%q: expected an error", invalid)
+			}
+		}
+	})
+
+	t.Run("Generate", func(t *testing.T) {
+		policy, err := ParsePasswordRules("minlength: 8; maxlength: 12; required: digit; required: [-_]; allowed: lower; max-consecutive: 1")
+		if err != nil {
+			t.Fatalf("ParsePasswordRules failed: %v", err)
+		}
+		for i := 0; i < 50; i++ {
+			password, err := GenerateForPolicy(policy, DefaultOptions())
+			if err != nil {
+				t.Fatalf("GenerateForPolicy failed: %v", err)
+			}
+			if len(password) != 12 {
+				t.Errorf("Expected the length capped at 12, got %q", password)
+			}
+			if countIn(password, DIGITS) == 0 || countIn(password, "-_") == 0 {
+				t.Errorf("%q misses a required set", password)
+			}
+			if countIn(password, DIGITS+"-_"+LOWERCASE) != len(password) {
+				t.Errorf("%q uses characters that are not allowed", password)
+			}
+			if longestRun([]rune(password)) > 1 {
+				t.Errorf("%q repeats a character in a row", password)
+			}
+		}
+
+		opts := DefaultOptions()
+		opts.Exclude = DIGITS
+		if _, err := GenerateForPolicy(policy, opts); err == nil {
+			t.Errorf("Expected an error when a required set is excluded")
+		}
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		path := t.TempDir() + "/password-rules.json"
+		policies, err := LoadPolicies(path)
+		if err != nil || len(policies) != 0 {
+			t.Fatalf("A missing file should hold no policies, got %v (%v)", policies, err)
+		}
+		rules := `{"Example.com": {"password-rules": "maxlength: 16; required: digit"}}`
+		if err := os.WriteFile(path, []byte(rules), 0600); err != nil {
+			t.Fatal(err)
+		}
+		policies, err = LoadPolicies(path)
+		if err != nil {
+			t.Fatalf("LoadPolicies failed: %v", err)
+		}
+		for _, url := range []string{"https://example.com/login", "login.EXAMPLE.com", "http://a.b.example.com:8080"} {
+			if domain, policy, ok := policies.ForURL(url); !ok || domain != "example.com" || policy.MaxLength != 16 {
+				t.Errorf("%s: expected the example.com policy", url)
+			}
+		}
+		for _, url := range []string{"https://notexample.com", "example.org", ""} {
+			if _, _, ok := policies.ForURL(url); ok {
+				t.Errorf("%s: expected no policy", url)
+			}
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package generator
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Wordlists passphrases can be drawn from
+const (
+	//EFF large wordlist, 7776 words for five dice
+	WORDLIST_LARGE string = "eff-large"
+	//EFF short wordlist 2.0, 1296 words for four dice, each with a unique three letter prefix
+	WORDLIST_SHORT string = "eff-short"
+)
+
+// How the words of a passphrase are capitalized
+const (
+	CAPITALIZE_NONE string = ""
+	//First letter of every word
+	CAPITALIZE_WORDS string = "words"
+	//First letter of each word with a chance of one half, which adds a bit per word
+	CAPITALIZE_RANDOM string = "random"
+)
+
+// Bounds on the number of words in a passphrase
+const (
+	MIN_WORDS int = 3
+	MAX_WORDS int = 20
+)
+
+// Longest separator between words
+const MAX_SEPARATOR_LEN int = 8
+
+//go:embed wordlists/eff_large_wordlist.txt
+var effLargeWordlist string
+
+//go:embed wordlists/eff_short_wordlist_2_0.txt
+var effShortWordlist string
+
+var wordlists = map[string]func() []string{
+	WORDLIST_LARGE: sync.OnceValue(func() []string { return parseWordlist(effLargeWordlist) }),
+	WORDLIST_SHORT: sync.OnceValue(func() []string { return parseWordlist(effShortWordlist) }),
+}
+
+// What a generated passphrase looks like
+type PassphraseOptions struct {
+	Words int `json:"words"`
+	//WORDLIST_LARGE when empty
+	Wordlist   string `json:"wordlist,omitempty"`
+	Separator  string `json:"separator"`
+	Capitalize string `json:"capitalize,omitempty"`
+	//Random digits, each appended to a random word
+	Digits int `json:"digits,omitempty"`
+}
+
+// A generated passphrase with its entropy in bits
+type Passphrase struct {
+	Passphrase string  `json:"passphrase"`
+	Entropy    float64 `json:"entropy"`
+}
+
+// Six words from the large list joined by dashes, about 77 bits
+func DefaultPassphraseOptions() PassphraseOptions {
+	return PassphraseOptions{Words: 6, Wordlist: WORDLIST_LARGE, Separator: "-"}
+}
+
+// Generates a diceware passphrase from crypto/rand. Each word is drawn uniformly from the
+// wordlist, like rolling dice. The entropy counts the words, random capitalization and the
+// digit values; where the digits went is not counted, so it is a lower bound.
+func GeneratePassphrase(opts PassphraseOptions) (Passphrase, error) {
+	if opts.Words < MIN_WORDS || opts.Words > MAX_WORDS {
+		return Passphrase{}, fmt.Errorf("Word count must be between %d and %d", MIN_WORDS, MAX_WORDS)
+	}
+	if len(opts.Separator) > MAX_SEPARATOR_LEN {
+		return Passphrase{}, fmt.Errorf("Separator can be at most %d characters", MAX_SEPARATOR_LEN)
+	}
+	if opts.Digits < 0 || opts.Digits > opts.Words {
+		return Passphrase{}, fmt.Errorf("Digits must be between 0 and the word count")
+	}
+	name := opts.Wordlist
+	if name == "" {
+		name = WORDLIST_LARGE
+	}
+	wordlist, ok := wordlists[name]
+	if !ok {
+		return Passphrase{}, fmt.Errorf("Unknown wordlist %q", opts.Wordlist)
+	}
+	list := wordlist()
+
+	words := make([]string, opts.Words)
+	entropy := float64(opts.Words) * math.Log2(float64(len(list)))
+	for i := range words {
+		index, err := randomIndex(len(list))
+		if err != nil {
+			return Passphrase{}, err
+		}
+		words[i] = list[index]
+
+		switch opts.Capitalize {
+		case CAPITALIZE_NONE:
+		case CAPITALIZE_WORDS:
+			words[i] = capitalize(words[i])
+		case CAPITALIZE_RANDOM:
+			coin, err := randomIndex(2)
+			if err != nil {
+				return Passphrase{}, err
+			}
+			if coin == 1 {
+				words[i] = capitalize(words[i])
+			}
+			entropy++
+		default:
+			return Passphrase{}, fmt.Errorf("Unknown capitalization %q", opts.Capitalize)
+		}
+	}
+
+	for i := 0; i < opts.Digits; i++ {
+		word, err := randomIndex(len(words))
+		if err != nil {
+			return Passphrase{}, err
+		}
+		digit, err := randomIndex(len(DIGITS))
+		if err != nil {
+			return Passphrase{}, err
+		}
+		words[word] += DIGITS[digit : digit+1]
+		entropy += math.Log2(float64(len(DIGITS)))
+	}
+
+	return Passphrase{Passphrase: strings.Join(words, opts.Separator), Entropy: entropy}, nil
+}
+
+// Words of a list in the EFF format, one dice roll and word per line separated by a tab
+func parseWordlist(contents string) []string {
+	var words []string
+	for _, line := range strings.Split(strings.TrimSpace(contents), "\n") {
+		_, word, _ := strings.Cut(strings.TrimSpace(line), "\t")
+		words = append(words, word)
+	}
+	return words
+}
+
+func capitalize(word string) string {
+	runes := []rune(word)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
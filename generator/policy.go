@@ -0,0 +1,274 @@
+package generator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Character classes named in password rules
+const (
+	//Apple's special class, which includes the space
+	RULES_SPECIAL string = "-~!@#$%^&*_+=`|(){}[:;\"'<>,.?] "
+	//Every printable ASCII character, also used for the unicode class
+	RULES_ASCII_PRINTABLE string = " !\"#$%&'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~"
+)
+
+// A site's password policy, parsed from Apple's password rules syntax, e.g.
+// "minlength: 8; maxlength: 16; required: digit; allowed: [-_]"
+type Policy struct {
+	//The rules the policy was parsed from
+	Rules          string
+	MinLength      int
+	MaxLength      int
+	MaxConsecutive int
+	//Each set must contribute at least one character
+	Required []string
+	//Characters that may be used besides the required sets
+	Allowed string
+}
+
+// Policies of a rules file keyed by domain
+type Policies map[string]Policy
+
+// An entry of a rules file, in the format of Apple's password-manager-resources quirks
+type rulesEntry struct {
+	PasswordRules string `json:"password-rules"`
+}
+
+// Parses password rules. Rules are separated by semicolons; unknown rules are ignored, as the
+// syntax asks, so rules written for newer clients still parse.
+func ParsePasswordRules(rules string) (Policy, error) {
+	policy := Policy{Rules: rules}
+	var allowed []string
+	for _, rule := range splitOutsideBrackets(rules, ";") {
+		name, value, found := strings.Cut(rule, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if name == "" && !found {
+			continue
+		}
+		if !found {
+			return Policy{}, fmt.Errorf("Rule %q has no value", rule)
+		}
+
+		var err error
+		switch name {
+		case "required":
+			var set string
+			if set, err = parseCharacterClasses(value); err == nil {
+				policy.Required = append(policy.Required, set)
+			}
+		case "allowed":
+			var set string
+			if set, err = parseCharacterClasses(value); err == nil {
+				allowed = append(allowed, set)
+			}
+		case "minlength":
+			var n int
+			if n, err = parseRuleNumber(value); err == nil {
+				policy.MinLength = max(policy.MinLength, n)
+			}
+		case "maxlength":
+			var n int
+			if n, err = parseRuleNumber(value); err == nil && (policy.MaxLength == 0 || n < policy.MaxLength) {
+				policy.MaxLength = n
+			}
+		case "max-consecutive":
+			var n int
+			if n, err = parseRuleNumber(value); err == nil && (policy.MaxConsecutive == 0 || n < policy.MaxConsecutive) {
+				policy.MaxConsecutive = n
+			}
+		}
+		if err != nil {
+			return Policy{}, fmt.Errorf("Invalid %s rule. %w", name, err)
+		}
+	}
+
+	if policy.MaxLength > 0 && policy.MaxLength < policy.MinLength {
+		return Policy{}, fmt.Errorf("maxlength %d is below minlength %d", policy.MaxLength, policy.MinLength)
+	}
+	if len(allowed) == 0 && len(policy.Required) == 0 {
+		allowed = append(allowed, RULES_ASCII_PRINTABLE)
+	}
+	policy.Allowed = union(allowed...)
+	return policy, nil
+}
+
+// Generates a password that satisfies the policy. The length of opts is used when the policy
+// allows it and moved into its bounds otherwise; exclusions and NoRepeat still apply, while the
+// character classes of opts are replaced by the policy's.
+func GenerateForPolicy(policy Policy, opts Options) (string, error) {
+	length := opts.Length
+	if policy.MaxLength > 0 {
+		length = min(length, policy.MaxLength)
+	}
+	length = max(length, policy.MinLength, len(policy.Required))
+	if length < 1 || length > MAX_LENGTH {
+		return "", fmt.Errorf("Policy needs a length between 1 and %d", MAX_LENGTH)
+	}
+
+	excluded := opts.Exclude
+	if opts.ExcludeAmbiguous {
+		excluded += AMBIGUOUS
+	}
+	keep := func(set string) []rune {
+		var chars []rune
+		for _, char := range set {
+			if !strings.ContainsRune(excluded, char) {
+				chars = append(chars, char)
+			}
+		}
+		return chars
+	}
+
+	var classes []class
+	for i, set := range policy.Required {
+		chars := keep(set)
+		if len(chars) == 0 {
+			return "", fmt.Errorf("Every character of required set %d is excluded", i+1)
+		}
+		classes = append(classes, class{name: fmt.Sprintf("required set %d", i+1), chars: chars, minimum: 1})
+	}
+	all := keep(union(append(policy.Required, policy.Allowed)...))
+	if len(all) == 0 {
+		return "", fmt.Errorf("Every allowed character is excluded")
+	}
+	if opts.NoRepeat && len(all) < length {
+		return "", fmt.Errorf("Only %d characters are allowed, too few for %d without repeats", len(all), length)
+	}
+	return generate(classes, all, length, opts.NoRepeat, policy.MaxConsecutive)
+}
+
+// Reads a rules file mapping domains to password rules, in the JSON format of Apple's
+// password-manager-resources quirks: {"example.com": {"password-rules": "minlength: 8"}}. A
+// missing file holds no policies.
+func LoadPolicies(path string) (Policies, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Policies{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read password rules. %w", err)
+	}
+	var entries map[string]rulesEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Could not parse password rules. %w", err)
+	}
+
+	policies := Policies{}
+	for domain, entry := range entries {
+		policy, err := ParsePasswordRules(entry.PasswordRules)
+		if err != nil {
+			return nil, fmt.Errorf("Password rules of %s: %w", domain, err)
+		}
+		policies[strings.ToLower(domain)] = policy
+	}
+	return policies, nil
+}
+
+// Finds the policy of the site at rawURL. A policy for a domain covers its subdomains too, so
+// rules for example.com apply to login.example.com.
+func (policies Policies) ForURL(rawURL string) (string, Policy, bool) {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", Policy{}, false
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	for host != "" {
+		if policy, ok := policies[host]; ok {
+			return host, policy, true
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+	return "", Policy{}, false
+}
+
+// Characters of a comma separated list of classes and bracketed custom classes, e.g.
+// "upper, lower, [-_]"
+func parseCharacterClasses(value string) (string, error) {
+	var sets []string
+	for _, item := range splitOutsideBrackets(value, ",") {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+		case item == "upper":
+			sets = append(sets, UPPERCASE)
+		case item == "lower":
+			sets = append(sets, LOWERCASE)
+		case item == "digit":
+			sets = append(sets, DIGITS)
+		case item == "special":
+			sets = append(sets, RULES_SPECIAL)
+		case item == "ascii-printable", item == "unicode":
+			sets = append(sets, RULES_ASCII_PRINTABLE)
+		case strings.HasPrefix(item, "[") && strings.HasSuffix(item, "]") && len(item) > 2:
+			//Only printable ASCII can be typed everywhere, so other characters are dropped
+			var custom []rune
+			for _, char := range item[1 : len(item)-1] {
+				if strings.ContainsRune(RULES_ASCII_PRINTABLE, char) {
+					custom = append(custom, char)
+				}
+			}
+			sets = append(sets, string(custom))
+		default:
+			return "", fmt.Errorf("Unknown character class %q", item)
+		}
+	}
+	set := union(sets...)
+	if set == "" {
+		return "", fmt.Errorf("No characters in %q", value)
+	}
+	return set, nil
+}
+
+func parseRuleNumber(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a non-negative number", value)
+	}
+	return n, nil
+}
+
+// Splits s at sep, except inside a bracketed custom class. A ] right after the opening [ is
+// part of the class.
+func splitOutsideBrackets(s string, sep string) []string {
+	var parts []string
+	start, inClass := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case inClass:
+			if s[i] == ']' && s[i-1] != '[' {
+				inClass = false
+			}
+		case s[i] == '[':
+			inClass = true
+		case strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Characters that appear in any of sets, sorted and without duplicates
+func union(sets ...string) string {
+	var chars []rune
+	for _, set := range sets {
+		chars = append(chars, []rune(set)...)
+	}
+	slices.Sort(chars)
+	return string(slices.Compact(chars))
+}
@@ -3,3 +3,5 @@ module PasswordManager
 go 1.24.1
 
 require golang.org/x/crypto v0.40.0
+
+require golang.org/x/sys v0.34.0
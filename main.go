@@ -1,33 +1,160 @@
 package main
 
 import (
+	"PasswordManager/breach"
 	"PasswordManager/controller"
+	"PasswordManager/crypto"
+	"PasswordManager/generator"
+	"PasswordManager/otp"
+	"PasswordManager/strength"
 	"PasswordManager/vault"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
-var globalApp controller.App
+var globalApp *controller.App
+
+const defaultExpiryWindowDays int = 30
+
+// Per-site password rules in the app directory
+const passwordRulesFileName string = "password-rules.json"
+
+// Largest keyfile accepted from the browser, and the largest sign-in or signup request body,
+// which holds the keyfile in base64
+const (
+	maxKeyfileSize     int   = 1 << 20
+	maxAuthRequestSize int64 = 2 << 20
+)
 
 type SignupRequest struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Username          string `json:"username"`
+	Email             string `json:"email"`
+	Password          string `json:"password"`
+	CreateRecoveryKey bool   `json:"createRecoveryKey,omitempty"`
+	//Keyfile contents, base64 in JSON, from a file upload
+	Keyfile         []byte `json:"keyfile,omitempty"`
+	GenerateKeyfile bool   `json:"generateKeyfile,omitempty"`
+	//TOTP or backup code, for accounts with two-factor sign-in
+	Code string `json:"code,omitempty"`
+}
+type RecoveryRequest struct {
+	Username    string `json:"username"`
+	RecoveryKey string `json:"recoveryKey"`
+	NewPassword string `json:"newPassword,omitempty"`
+	//Recovery shares, used instead of RecoveryKey when given
+	Shares []string `json:"shares,omitempty"`
+}
+type SharesRequest struct {
+	MasterPassword string `json:"masterPassword"`
+	Total          int    `json:"total"`
+	Threshold      int    `json:"threshold"`
+}
+type SharesResponse struct {
+	Message string   `json:"message"`
+	Shares  []string `json:"shares,omitempty"`
 }
 type AuthResponse struct {
 	Message     string `json:"message"`
 	Success     bool   `json:"success"`
 	RedirectURL string `json:"redirectUrl,omitempty"` // Add an optional redirect URL field
+	KDFUpgraded bool   `json:"kdfUpgraded,omitempty"`
+	//Only sent once, right after signup
+	RecoveryKey string `json:"recoveryKey,omitempty"`
+	//Generated keyfile, only sent once right after signup
+	Keyfile []byte `json:"keyfile,omitempty"`
+	//The password was right but a two-factor code has to be sent along
+	TwoFactorRequired bool `json:"twoFactorRequired,omitempty"`
+}
+
+type RevealRequest struct {
+	ID    string `json:"id"`
+	Field string `json:"field"`
+	//"reveal" (default) or "copy"
+	Purpose        string `json:"purpose,omitempty"`
+	MasterPassword string `json:"masterPassword,omitempty"`
+}
+type ChangePasswordRequest struct {
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+type TwoFactorRequest struct {
+	Code           string `json:"code,omitempty"`
+	MasterPassword string `json:"masterPassword,omitempty"`
+}
+type TwoFactorResponse struct {
+	Message string `json:"message,omitempty"`
+	//Only sent once, when two-factor sign-in is turned on or the codes are replaced
+	BackupCodes []string `json:"backupCodes,omitempty"`
+}
+type ExportRequest struct {
+	MasterPassword string `json:"masterPassword,omitempty"`
+}
+type AddCredentialResponse struct {
+	//The password is easy to guess, Strength tells why
+	Weak     bool             `json:"weak,omitempty"`
+	Strength *strength.Result `json:"strength,omitempty"`
+	Message  string           `json:"message,omitempty"`
+}
+type GenerateResponse struct {
+	Password string `json:"password,omitempty"`
+	//Bits of entropy, sent for passphrases
+	Entropy float64 `json:"entropy,omitempty"`
+	//Domain whose password rules were followed
+	Policy  string `json:"policy,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+type RevealResponse struct {
+	Value            string `json:"value,omitempty"`
+	RepromptRequired bool   `json:"repromptRequired,omitempty"`
+	Message          string `json:"message,omitempty"`
 }
 
 func main() {
-	globalApp = *controller.NewApp()
+	requireReprompt := flag.Bool("reprompt-reveal", false, "require re-entering the master password before revealing secrets")
+	minPasswordScore := flag.Int("min-password-score", 3, "least strength score from 0 to 4 that new master passwords need")
+	importBreaches := flag.String("import-hibp", "", "import a Have I Been Pwned SHA-1 download (range file directory or ordered file) for offline breach checks, then exit")
+	kdfTarget := flag.Duration("kdf-target", 500*time.Millisecond, "unlock time to calibrate the KDF of new accounts to, 0 for the fixed defaults")
+	recalibrateKDF := flag.Bool("recalibrate-kdf", false, "calibrate the KDF again instead of reusing the stored calibration")
+	flag.Parse()
+
+	//Never handle secrets with primitives that give wrong answers
+	if err := crypto.SelfTest(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+
+	if *importBreaches != "" {
+		imported, err := breach.Import(*importBreaches)
+		if err != nil {
+			log.Fatalf("Breach data import failed: %v", err)
+		}
+		fmt.Printf("Imported %d breached password hashes\n", imported)
+		return
+	}
+
+	globalApp = controller.NewApp()
+	globalApp.RequireRepromptForReveal = *requireReprompt
+	globalApp.MinMasterPasswordScore = *minPasswordScore
+	if *kdfTarget > 0 {
+		params, err := controller.CalibratedKDFPolicy(*kdfTarget, *recalibrateKDF)
+		if err != nil {
+			log.Fatalf("KDF calibration failed: %v", err)
+		}
+		globalApp.KDFPolicy = params
+		log.Printf("New accounts use Argon2id with %d KiB, %d passes and %d lanes", params.Memory, params.Time, params.Parallelism)
+	}
 
 	mux := http.NewServeMux()
 
@@ -38,19 +165,49 @@ func main() {
 	mux.HandleFunc("/api/signup", handleSignup)
 	mux.HandleFunc("/api/signin", handleSignin)
 	mux.HandleFunc("/api/signout", handleSignout)
+	mux.HandleFunc("/api/signin-recovery", handleRecoverySignin)
+	mux.HandleFunc("/api/emergency-kit", handleEmergencyKit)
 	mux.HandleFunc("/api/credentials", handleCredentials)
 	mux.HandleFunc("/api/add-credential", handleAddCredential)
+	mux.HandleFunc("/api/credentials/expiring", handleExpiringCredentials)
+	mux.HandleFunc("/api/credentials/reveal", handleRevealCredential)
+	mux.HandleFunc("/api/credentials/otp", handleOTPCode)
+	mux.HandleFunc("/api/export", handleExport)
+	mux.HandleFunc("/api/change-password", handleChangePassword)
+	mux.HandleFunc("/api/recovery-shares", handleRecoveryShares)
+	mux.HandleFunc("/api/two-factor", handleTwoFactorStatus)
+	mux.HandleFunc("/api/two-factor/enroll", handleTwoFactorEnroll)
+	mux.HandleFunc("/api/two-factor/confirm", handleTwoFactorConfirm)
+	mux.HandleFunc("/api/two-factor/disable", handleTwoFactorDisable)
+	mux.HandleFunc("/api/two-factor/backup-codes", handleBackupCodes)
+	mux.HandleFunc("/api/generate", handleGenerate)
+	mux.HandleFunc("/api/audit", handleAudit)
 
 	port := 8080
 
-	fmt.Printf("Server starting on http://localhost:%v\n", port)
-	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), mux))
+	server := &http.Server{Addr: ":" + strconv.Itoa(port), Handler: mux}
 
+	//On Ctrl+C or a termination signal let running requests finish, then wipe the keys
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		server.Shutdown(context.Background())
+		globalApp.SignOut()
+		close(stopped)
+	}()
+
+	fmt.Printf("Server starting on http://localhost:%v\n", port)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-stopped
 }
 
 func handleStatus(w http.ResponseWriter, r *http.Request) {
 	var status bool
-	if globalApp.CurrentUser == nil {
+	if !globalApp.IsSignedIn() {
 		status = false
 	} else {
 		status = true
@@ -62,7 +219,7 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleSignup(w http.ResponseWriter, r *http.Request) {
-	if globalApp.CurrentUser != nil {
+	if globalApp.IsSignedIn() {
 		http.Redirect(w, r, "/api/vault", 302)
 		return
 	}
@@ -72,17 +229,36 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(injected))
 		return
 	} else if r.Method == http.MethodPost {
-		body, _ := io.ReadAll(r.Body)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuthRequestSize))
+		if err != nil {
+			http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		var signupData SignupRequest
 		json.Unmarshal(body, &signupData)
-		err := globalApp.SignUp(signupData.Username, signupData.Password)
+		keyfile, err := requestKeyfile(signupData)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		result, err := globalApp.SignUp(signupData.Username, signupData.Password, controller.SignUpOptions{
+			CreateRecoveryKey: signupData.CreateRecoveryKey,
+			Keyfile:           keyfile,
+			GenerateKeyfile:   signupData.GenerateKeyfile,
+		})
+		if errors.Is(err, controller.ErrWeakPassword) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(AuthResponse{Message: weakPasswordMessage(err)})
+			return
+		}
 		if err != nil {
 			http.Error(w, "Something went wrong", 400)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(AuthResponse{RedirectURL: "/index.html/?form=signin", Success: true})
+		json.NewEncoder(w).Encode(AuthResponse{RedirectURL: "/index.html/?form=signin", Success: true, RecoveryKey: result.RecoveryKey, Keyfile: result.Keyfile})
 
 	} else {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -92,7 +268,7 @@ func handleSignup(w http.ResponseWriter, r *http.Request) {
 func handleAddCredential(w http.ResponseWriter, r *http.Request) {
 
 	var status bool
-	if globalApp.CurrentUser == nil {
+	if !globalApp.IsSignedIn() {
 		status = false
 	} else {
 		status = true
@@ -110,19 +286,32 @@ func handleAddCredential(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Something went wrong", 405)
 		return
 	}
-	err = globalApp.AddCredential(newUser.URL, newUser.Username, newUser.Password)
+	err = globalApp.AddCredential(newUser)
+	if errors.Is(err, otp.ErrInvalidURI) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(AddCredentialResponse{Message: err.Error()})
+		return
+	}
 	if err != nil {
 		http.Error(w, "Something went wrong", 405)
 		return
 	}
 
+	//Saved either way, but a guessable password is pointed out
+	var response AddCredentialResponse
+	if result := strength.Estimate(newUser.Password, newUser.Username, newUser.URL); result.Score < strength.SCORE_SAFELY_UNGUESSABLE {
+		response.Weak = true
+		response.Strength = &result
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	return
+	json.NewEncoder(w).Encode(response)
 }
 func handleCredentials(w http.ResponseWriter, r *http.Request) {
 
 	var status bool
-	if globalApp.CurrentUser == nil {
+	if !globalApp.IsSignedIn() {
 		status = false
 	} else {
 		status = true
@@ -133,8 +322,199 @@ func handleCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	query := r.URL.Query()
+	opts := controller.SearchOptions{Query: query.Get("q"), Sort: query.Get("sort")}
+	var err error
+	if value := query.Get("limit"); value != "" {
+		if opts.Limit, err = strconv.Atoi(value); err != nil || opts.Limit < 0 {
+			http.Error(w, "limit must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		if opts.Offset, err = strconv.Atoi(value); err != nil || opts.Offset < 0 {
+			http.Error(w, "offset must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	credentials, total := globalApp.SearchCredentials(opts)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(globalApp.DecryptedVault)
+	json.NewEncoder(w).Encode(credentials)
+}
+
+// Lists items that are expired or due for rotation within ?days=N (default 30)
+func handleExpiringCredentials(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	days := defaultExpiryWindowDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			http.Error(w, "days must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(globalApp.GetExpiringCredentials(days))
+}
+
+// Reveals one secret field of one item. The master password may be sent along to satisfy a re-prompt.
+func handleRevealCredential(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var revealData RevealRequest
+	if err := json.Unmarshal(body, &revealData); err != nil {
+		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	var err error
+	if revealData.MasterPassword != "" {
+		err = globalApp.VerifyMasterPassword(revealData.MasterPassword)
+	}
+	var value string
+	if err == nil {
+		if revealData.Purpose == "copy" {
+			value, err = globalApp.CopyCredentialField(revealData.ID, revealData.Field)
+		} else {
+			value, err = globalApp.RevealCredentialField(revealData.ID, revealData.Field)
+		}
+	}
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(RevealResponse{Value: value})
+}
+
+// Returns the current one-time code of ?id= and, for TOTP, the seconds it stays valid. The
+// master password may be sent along to satisfy a re-prompt.
+func handleOTPCode(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var codeData RevealRequest
+	if err := json.Unmarshal(body, &codeData); err != nil {
+		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	var err error
+	if codeData.MasterPassword != "" {
+		err = globalApp.VerifyMasterPassword(codeData.MasterPassword)
+	}
+	var code controller.OTPCode
+	if err == nil {
+		code, err = globalApp.GenerateOTPCode(codeData.ID)
+	}
+	if err != nil {
+		writeAccessError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(code)
+}
+
+// Downloads every item in plaintext JSON. Needs a re-prompt when any item is flagged for it.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var exportData ExportRequest
+	json.Unmarshal(body, &exportData)
+
+	w.Header().Set("Content-Type", "application/json")
+	var err error
+	if exportData.MasterPassword != "" {
+		err = globalApp.VerifyMasterPassword(exportData.MasterPassword)
+	}
+	if err == nil {
+		var credentials []vault.Credential
+		credentials, err = globalApp.ExportCredentials()
+		if err == nil {
+			w.Header().Set("Content-Disposition", `attachment; filename="vault-export.json"`)
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(credentials)
+			return
+		}
+	}
+	writeAccessError(w, err)
+}
+
+func handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var changeData ChangePasswordRequest
+	if err := json.Unmarshal(body, &changeData); err != nil {
+		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := globalApp.ChangeMasterPassword(changeData.OldPassword, changeData.NewPassword)
+	if errors.Is(err, controller.ErrWrongReprompt) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "Current master password is wrong"})
+		return
+	}
+	if errors.Is(err, controller.ErrWeakPassword) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(AuthResponse{Message: weakPasswordMessage(err)})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "Could not change master password"})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AuthResponse{Message: "Master password changed", Success: true})
+}
+
+// Maps errors from reveal, copy and export to a status code and JSON body
+func writeAccessError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, controller.ErrRepromptRequired), errors.Is(err, controller.ErrWrongReprompt):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(RevealResponse{RepromptRequired: true, Message: err.Error()})
+	case errors.Is(err, controller.ErrCredentialNotFound), errors.Is(err, controller.ErrFieldNotFound), errors.Is(err, controller.ErrNoOTP):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(RevealResponse{Message: err.Error()})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(RevealResponse{Message: "Something went wrong"})
+	}
 }
 
 func handleSignin(w http.ResponseWriter, r *http.Request) {
@@ -144,22 +524,369 @@ func handleSignin(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(injected))
 		return
 	} else if r.Method == http.MethodPost {
-		body, _ := io.ReadAll(r.Body)
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAuthRequestSize))
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(AuthResponse{Message: "Request too large"})
+			return
+		}
 		var signupData SignupRequest
 		json.Unmarshal(body, &signupData)
-		err := globalApp.SignIn(signupData.Username, signupData.Password)
+		keyfile, err := requestKeyfile(signupData)
 		if err != nil {
-			http.Error(w, "Something went wrong", 400)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(AuthResponse{Message: err.Error()})
+			return
+		}
+		result, err := globalApp.SignInWithCode(signupData.Username, signupData.Password, keyfile, signupData.Code)
+		if err != nil {
+			writeSigninError(w, err)
 			return
 		}
-		w.WriteHeader(http.StatusCreated)                                                                                           // 201 Created
-		json.NewEncoder(w).Encode(AuthResponse{Message: "User signed up successfully!", Success: true, RedirectURL: "/vault.html"}) // Include redirect URL
+		response := AuthResponse{Message: "User signed up successfully!", Success: true, RedirectURL: "/vault.html", KDFUpgraded: result.KDFUpgraded}
+		if result.KDFUpgraded {
+			response.Message = "Signed in. Your vault was upgraded to stronger key derivation settings."
+		}
+		w.WriteHeader(http.StatusCreated)   // 201 Created
+		json.NewEncoder(w).Encode(response) // Include redirect URL
+		return
+	}
+}
+
+// Unlocks the vault with a recovery key and replaces the forgotten master password
+func handleRecoverySignin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var recoveryData RecoveryRequest
+	if err := json.Unmarshal(body, &recoveryData); err != nil {
+		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if len(recoveryData.Shares) > 0 {
+		err = globalApp.SignInWithRecoveryShares(recoveryData.Username, recoveryData.Shares, recoveryData.NewPassword)
+	} else {
+		err = globalApp.SignInWithRecoveryKey(recoveryData.Username, recoveryData.RecoveryKey, recoveryData.NewPassword)
+	}
+	if err != nil {
+		writeSigninError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(AuthResponse{Message: "Vault recovered. Use your new master password from now on.", Success: true, RedirectURL: "/vault.html"})
+}
+
+// Splits a new recovery key into shares for several trusted people
+func handleRecoveryShares(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	var sharesData SharesRequest
+	if err := json.Unmarshal(body, &sharesData); err != nil {
+		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	shares, err := globalApp.CreateRecoveryShares(sharesData.MasterPassword, sharesData.Total, sharesData.Threshold)
+	if errors.Is(err, controller.ErrWrongReprompt) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(SharesResponse{Message: "Master password is wrong"})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SharesResponse{Message: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SharesResponse{Message: "Hand each share to a different person", Shares: shares})
+}
+
+// Reports whether two-factor sign-in is on and how many backup codes are left
+func handleTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() || r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(globalApp.TwoFactorStatus())
+}
+
+// Starts enrolling an authenticator app and returns its otpauth URI
+func handleTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enrollment, err := globalApp.BeginTwoFactorEnrollment()
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// Turns two-factor sign-in on with a first code from the enrolled app
+func handleTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	twoFactorData, ok := readTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	codes, err := globalApp.ConfirmTwoFactorEnrollment(twoFactorData.Code)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TwoFactorResponse{Message: "Two-factor sign-in is on. Keep these backup codes somewhere safe.", BackupCodes: codes})
+}
+
+// Turns two-factor sign-in off, the master password has to be sent along
+func handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	twoFactorData, ok := readTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := globalApp.DisableTwoFactor(twoFactorData.MasterPassword); err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TwoFactorResponse{Message: "Two-factor sign-in is off"})
+}
+
+// Replaces the backup codes, the master password has to be sent along
+func handleBackupCodes(w http.ResponseWriter, r *http.Request) {
+	twoFactorData, ok := readTwoFactorRequest(w, r)
+	if !ok {
+		return
+	}
+	codes, err := globalApp.RegenerateBackupCodes(twoFactorData.MasterPassword)
+	if err != nil {
+		writeTwoFactorError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(TwoFactorResponse{Message: "The old backup codes no longer work", BackupCodes: codes})
+}
+
+func readTwoFactorRequest(w http.ResponseWriter, r *http.Request) (TwoFactorRequest, bool) {
+	var twoFactorData TwoFactorRequest
+	if !globalApp.IsSignedIn() || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return twoFactorData, false
+	}
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &twoFactorData); err != nil {
+		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		return twoFactorData, false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return twoFactorData, true
+}
+
+func writeTwoFactorError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, controller.ErrWrongReprompt):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(TwoFactorResponse{Message: "Master password is wrong"})
+	case errors.Is(err, controller.ErrWrongCode), errors.Is(err, controller.ErrNoEnrollment),
+		errors.Is(err, controller.ErrTwoFactorEnabled), errors.Is(err, controller.ErrTwoFactorDisabled):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(TwoFactorResponse{Message: err.Error()})
+	case errors.Is(err, controller.ErrVaultLocked):
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	default:
+		log.Printf("Two-factor change failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(TwoFactorResponse{Message: "Something went wrong"})
+	}
+}
+
+// Generates a random password, or with ?type=passphrase a diceware passphrase. A POST may send
+// options, a GET uses the defaults. With ?url= a password follows the rules of that site when
+// the rules file has any.
+func handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() || (r.Method != http.MethodGet && r.Method != http.MethodPost) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+
+	passphrase := r.URL.Query().Get("type") == "passphrase"
+	opts := generator.DefaultOptions()
+	passphraseOpts := generator.DefaultPassphraseOptions()
+	if r.Method == http.MethodPost {
+		//Sent options replace the defaults as a whole, so a dropped class keeps no default minimum
+		body, _ := io.ReadAll(r.Body)
+		var err error
+		if passphrase {
+			passphraseOpts = generator.PassphraseOptions{}
+			err = json.Unmarshal(body, &passphraseOpts)
+		} else {
+			opts = generator.Options{}
+			err = json.Unmarshal(body, &opts)
+		}
+		if err != nil {
+			http.Error(w, "Something went wrong", http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	var response GenerateResponse
+	var err error
+	if passphrase {
+		var result generator.Passphrase
+		result, err = generator.GeneratePassphrase(passphraseOpts)
+		response = GenerateResponse{Password: result.Passphrase, Entropy: result.Entropy}
+	} else if domain, policy, ok := passwordPolicyFor(r.URL.Query().Get("url")); ok {
+		response.Password, err = generator.GenerateForPolicy(policy, opts)
+		response.Policy = domain
+	} else {
+		response.Password, err = generator.Generate(opts)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(GenerateResponse{Message: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// Reports weak, reused and old passwords and other risky items in the vault
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if !globalApp.IsSignedIn() || r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := globalApp.CheckVaultHealth()
+	if errors.Is(err, controller.ErrVaultLocked) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		log.Printf("Vault health check failed: %v", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// Password policy of the site at rawURL from the rules file in the app directory. A broken
+// rules file is logged and treated as empty, so generating still works.
+func passwordPolicyFor(rawURL string) (string, generator.Policy, bool) {
+	if rawURL == "" {
+		return "", generator.Policy{}, false
+	}
+	appDir, err := vault.GetAppConfigDir()
+	if err != nil {
+		return "", generator.Policy{}, false
+	}
+	policies, err := generator.LoadPolicies(path.Join(appDir, passwordRulesFileName))
+	if err != nil {
+		log.Printf("Ignoring password rules: %v", err)
+		return "", generator.Policy{}, false
+	}
+	return policies.ForURL(rawURL)
+}
+
+// Renders the printable emergency kit for a recovery key the browser still holds from signup
+func handleEmergencyKit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var kitData RecoveryRequest
+	if err := json.Unmarshal(body, &kitData); err != nil {
+		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+	page, err := controller.EmergencyKit(kitData.Username, kitData.RecoveryKey)
+	if err != nil {
+		http.Error(w, "Invalid recovery key", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="emergency-kit.html"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(page)
+}
+
+// Keyfile of a signup or signin request. Only uploaded contents are taken, never a path on the
+// server, so requests cannot make the server read its own files.
+func requestKeyfile(req SignupRequest) ([]byte, error) {
+	if len(req.Keyfile) > maxKeyfileSize {
+		return nil, fmt.Errorf("Keyfiles can be at most %d KiB", maxKeyfileSize/1024)
+	}
+	return req.Keyfile, nil
+}
+
+// Tells the user why a new master password was rejected
+func weakPasswordMessage(err error) string {
+	return "Choose a stronger master password. " + strings.TrimPrefix(err.Error(), controller.ErrWeakPassword.Error()+": ")
+}
+
+// Maps sign-in failures to distinct status codes so the UI can tell a typo from a damaged vault
+func writeSigninError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case errors.Is(err, controller.ErrWrongPassword):
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "Wrong username or master password"})
+	case errors.Is(err, controller.ErrKeyfileRequired):
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "This vault also needs its keyfile"})
+	case errors.Is(err, controller.ErrTwoFactorRequired):
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "Enter the code from your authenticator app or a backup code", TwoFactorRequired: true})
+	case errors.Is(err, controller.ErrWrongCode):
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "Wrong or already used code", TwoFactorRequired: true})
+	case errors.Is(err, controller.ErrWeakPassword):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(AuthResponse{Message: weakPasswordMessage(err)})
+	case errors.Is(err, controller.ErrNotEnoughShares), errors.Is(err, controller.ErrNoRecoveryKey), errors.Is(err, controller.ErrNoRecoveryShares):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(AuthResponse{Message: err.Error()})
+	case errors.Is(err, controller.ErrVaultMissing):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "The vault file is missing"})
+	case errors.Is(err, controller.ErrVaultCorrupt):
+		log.Printf("Sign in failed on a corrupt vault: %v", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "The vault file is damaged and cannot be opened"})
+	default:
+		log.Printf("Sign in failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(AuthResponse{Message: "Something went wrong"})
+	}
 }
 
 func handleSignout(w http.ResponseWriter, r *http.Request) {
-	if globalApp.CurrentUser != nil {
+	if globalApp.IsSignedIn() {
 		globalApp.SignOut()
 	}
 
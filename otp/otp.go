@@ -0,0 +1,217 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of one-time password, as in the host part of an otpauth URI
+const (
+	TYPE_TOTP string = "totp"
+	TYPE_HOTP string = "hotp"
+)
+
+// HMAC hash functions an otpauth URI may name
+const (
+	ALGORITHM_SHA1   string = "SHA1"
+	ALGORITHM_SHA256 string = "SHA256"
+	ALGORITHM_SHA512 string = "SHA512"
+)
+
+// Defaults of Google Authenticator's key URI format, used when the URI leaves them out
+const (
+	DEFAULT_DIGITS int = 6
+	DEFAULT_PERIOD int = 30
+	MIN_DIGITS     int = 6
+	MAX_DIGITS     int = 8
+	//Bytes of a new secret, the output size of SHA-1 as RFC 4226 recommends
+	SECRET_LEN int = 20
+)
+
+var ErrInvalidURI = errors.New("not a valid otpauth URI")
+
+// Secret and settings of a TOTP (RFC 6238) or HOTP (RFC 4226) generator
+type Key struct {
+	Type      string
+	Issuer    string
+	Account   string
+	Secret    []byte
+	Algorithm string
+	Digits    int
+	//Seconds each TOTP code is valid for
+	Period int
+	//Counter of the next HOTP code
+	Counter uint64
+}
+
+// Secrets are base32 without padding, often shown in groups and in lower case
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Creates a TOTP key with a random secret and the default settings, which every authenticator
+// app understands
+func GenerateTOTPKey(issuer string, account string) (*Key, error) {
+	secret := make([]byte, SECRET_LEN)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, fmt.Errorf("Could not generate a TOTP secret. %w", err)
+	}
+	return &Key{Type: TYPE_TOTP, Issuer: issuer, Account: account, Secret: secret, Algorithm: ALGORITHM_SHA1, Digits: DEFAULT_DIGITS, Period: DEFAULT_PERIOD}, nil
+}
+
+// The secret in base32, for typing into an authenticator app
+func (key *Key) EncodedSecret() string {
+	return secretEncoding.EncodeToString(key.Secret)
+}
+
+// Parses a key URI like otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Example
+func ParseURI(uri string) (*Key, error) {
+	parsed, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil || parsed.Scheme != "otpauth" {
+		return nil, ErrInvalidURI
+	}
+	key := &Key{Type: strings.ToLower(parsed.Host), Algorithm: ALGORITHM_SHA1, Digits: DEFAULT_DIGITS}
+	if key.Type != TYPE_TOTP && key.Type != TYPE_HOTP {
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidURI, parsed.Host)
+	}
+
+	label := strings.TrimPrefix(parsed.Path, "/")
+	if issuer, account, found := strings.Cut(label, ":"); found {
+		key.Issuer, key.Account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+	} else {
+		key.Account = label
+	}
+	query := parsed.Query()
+	//The issuer parameter wins over the label prefix
+	if issuer := query.Get("issuer"); issuer != "" {
+		key.Issuer = issuer
+	}
+
+	secret := strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(query.Get("secret")))
+	key.Secret, err = secretEncoding.DecodeString(secret)
+	if err != nil || len(key.Secret) == 0 {
+		return nil, fmt.Errorf("%w: the secret is not base32", ErrInvalidURI)
+	}
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		key.Algorithm = strings.ToUpper(algorithm)
+		if newHash(key.Algorithm) == nil {
+			return nil, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidURI, algorithm)
+		}
+	}
+	if digits := query.Get("digits"); digits != "" {
+		key.Digits, err = strconv.Atoi(digits)
+		if err != nil || key.Digits < MIN_DIGITS || key.Digits > MAX_DIGITS {
+			return nil, fmt.Errorf("%w: digits must be %d to %d", ErrInvalidURI, MIN_DIGITS, MAX_DIGITS)
+		}
+	}
+	if key.Type == TYPE_TOTP {
+		key.Period = DEFAULT_PERIOD
+		if period := query.Get("period"); period != "" {
+			key.Period, err = strconv.Atoi(period)
+			if err != nil || key.Period <= 0 {
+				return nil, fmt.Errorf("%w: period must be a positive number of seconds", ErrInvalidURI)
+			}
+		}
+	} else {
+		counter := query.Get("counter")
+		if counter == "" {
+			return nil, fmt.Errorf("%w: hotp needs a counter", ErrInvalidURI)
+		}
+		key.Counter, err = strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad counter", ErrInvalidURI)
+		}
+	}
+	return key, nil
+}
+
+// The key as an otpauth URI, with every setting spelled out
+func (key *Key) URI() string {
+	query := url.Values{}
+	query.Set("secret", key.EncodedSecret())
+	if key.Issuer != "" {
+		query.Set("issuer", key.Issuer)
+	}
+	query.Set("algorithm", key.Algorithm)
+	query.Set("digits", strconv.Itoa(key.Digits))
+	if key.Type == TYPE_TOTP {
+		query.Set("period", strconv.Itoa(key.Period))
+	} else {
+		query.Set("counter", strconv.FormatUint(key.Counter, 10))
+	}
+	label := key.Account
+	if key.Issuer != "" {
+		label = key.Issuer + ":" + key.Account
+	}
+	uri := url.URL{Scheme: "otpauth", Host: key.Type, Path: "/" + label, RawQuery: query.Encode()}
+	return uri.String()
+}
+
+// The TOTP code for the time step holding now, and the seconds until the next step
+func (key *Key) TOTP(now time.Time) (string, int) {
+	period := int64(key.Period)
+	step := now.Unix() / period
+	return HOTP(key.Secret, uint64(step), key.Digits, key.Algorithm), int(period - now.Unix()%period)
+}
+
+// Checks a TOTP code against the time steps from skew steps before to skew steps after the one
+// holding now, to allow for clock drift. Steps up to and including after are refused, so a
+// caller that remembers the returned step never accepts the same code twice.
+func (key *Key) VerifyTOTP(code string, now time.Time, skew int, after int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != key.Digits {
+		return 0, false
+	}
+	current := now.Unix() / int64(key.Period)
+	for step := current - int64(skew); step <= current+int64(skew); step++ {
+		if step <= after || step < 0 {
+			continue
+		}
+		expected := HOTP(key.Secret, uint64(step), key.Digits, key.Algorithm)
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// The HOTP value of RFC 4226: the HMAC of the counter, dynamically truncated to digits
+// decimal digits
+func HOTP(secret []byte, counter uint64, digits int, algorithm string) string {
+	mac := hmac.New(newHash(algorithm), secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	modulus := uint32(1)
+	for range digits {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulus)
+}
+
+func newHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case ALGORITHM_SHA1:
+		return sha1.New
+	case ALGORITHM_SHA256:
+		return sha256.New
+	case ALGORITHM_SHA512:
+		return sha512.New
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package otp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	//RFC 4226 appendix D
+	secret := []byte("12345678901234567890")
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, want := range expected {
+		if got := HOTP(secret, uint64(counter), 6, ALGORITHM_SHA1); got != want {
+			t.Errorf("Counter %d: expected %s, got %s", counter, want, got)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	//RFC 6238 appendix B
+	secrets := map[string]string{
+		ALGORITHM_SHA1:   "12345678901234567890",
+		ALGORITHM_SHA256: "12345678901234567890123456789012",
+		ALGORITHM_SHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	cases := []struct {
+		time  int64
+		codes map[string]string
+	}{
+		{59, map[string]string{ALGORITHM_SHA1: "94287082", ALGORITHM_SHA256: "46119246", ALGORITHM_SHA512: "90693936"}},
+		{1111111109, map[string]string{ALGORITHM_SHA1: "07081804", ALGORITHM_SHA256: "68084774", ALGORITHM_SHA512: "25091201"}},
+		{1234567890, map[string]string{ALGORITHM_SHA1: "89005924", ALGORITHM_SHA256: "91819424", ALGORITHM_SHA512: "93441116"}},
+		{20000000000, map[string]string{ALGORITHM_SHA1: "65353130", ALGORITHM_SHA256: "77737706", ALGORITHM_SHA512: "47863826"}},
+	}
+	for _, c := range cases {
+		for algorithm, want := range c.codes {
+			key := &Key{Type: TYPE_TOTP, Secret: []byte(secrets[algorithm]), Algorithm: algorithm, Digits: 8, Period: 30}
+			code, remaining := key.TOTP(time.Unix(c.time, 0))
+			if code != want {
+				t.Errorf("%s at %d: expected %s, got %s", algorithm, c.time, want, code)
+			}
+			if want := 30 - int(c.time%30); remaining != want {
+				t.Errorf("At %d: expected %d seconds left, got %d", c.time, want, remaining)
+			}
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	key, err := GenerateTOTPKey("Pharoas", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := ParseURI(key.URI()); err != nil || parsed.EncodedSecret() != key.EncodedSecret() || parsed.Issuer != "Pharoas" {
+		t.Fatalf("A generated key should survive its URI, got %+v, %v", parsed, err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	current := now.Unix() / 30
+	codeAt := func(offset int) string {
+		code, _ := key.TOTP(now.Add(time.Duration(offset) * 30 * time.Second))
+		return code
+	}
+	for offset := -1; offset <= 1; offset++ {
+		if step, ok := key.VerifyTOTP(codeAt(offset), now, 1, 0); !ok || step != current+int64(offset) {
+			t.Errorf("A code %d steps off should be accepted, got %d, %v", offset, step, ok)
+		}
+	}
+	if _, ok := key.VerifyTOTP(codeAt(2), now, 1, 0); ok {
+		t.Error("A code two steps ahead should be refused")
+	}
+	if _, ok := key.VerifyTOTP(codeAt(0), now, 1, current); ok {
+		t.Error("A code of an already used step should be refused")
+	}
+	if _, ok := key.VerifyTOTP(codeAt(1), now, 1, current); !ok {
+		t.Error("A code of a later step should still be accepted")
+	}
+	if _, ok := key.VerifyTOTP("12345", now, 1, 0); ok {
+		t.Error("A code of the wrong length should be refused")
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	key, err := ParseURI("otpauth://totp/ACME%20Co:john.doe@email.com?secret=HXDMVJECJJWSRB3HWIZR4IFUGFTMXBOZ&issuer=ACME%20Co&algorithm=SHA256&digits=8&period=60")
+	if err != nil {
+		t.Fatalf("ParseURI failed: %v", err)
+	}
+	if key.Type != TYPE_TOTP || key.Issuer != "ACME Co" || key.Account != "john.doe@email.com" ||
+		key.Algorithm != ALGORITHM_SHA256 || key.Digits != 8 || key.Period != 60 || len(key.Secret) != 20 {
+		t.Errorf("Unexpected key %+v", key)
+	}
+	again, err := ParseURI(key.URI())
+	if err != nil || again.URI() != key.URI() {
+		t.Errorf("The URI should round trip, got %q from %q, %v", again.URI(), key.URI(), err)
+	}
+
+	//Defaults, a lower case secret in groups and the issuer from the label
+	key, err = ParseURI("otpauth://totp/Example:alice?secret=jbsw y3dp ehpk 3pxp")
+	if err != nil {
+		t.Fatalf("ParseURI failed: %v", err)
+	}
+	if key.Issuer != "Example" || key.Algorithm != ALGORITHM_SHA1 || key.Digits != DEFAULT_DIGITS || key.Period != DEFAULT_PERIOD || string(key.Secret) != "Hello!\xde\xad\xbe\xef" {
+		t.Errorf("Unexpected key %+v", key)
+	}
+
+	key, err = ParseURI("otpauth://hotp/Example:alice?secret=JBSWY3DPEHPK3PXP&counter=42")
+	if err != nil || key.Type != TYPE_HOTP || key.Counter != 42 || key.Period != 0 {
+		t.Errorf("Unexpected HOTP key %+v, %v", key, err)
+	}
+
+	for _, uri := range []string{
+		"https://example.com/?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://motp/alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/alice",
+		"otpauth://totp/alice?secret=not-base32!",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=4",
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&period=0",
+		"otpauth://hotp/alice?secret=JBSWY3DPEHPK3PXP",
+	} {
+		if _, err := ParseURI(uri); !errors.Is(err, ErrInvalidURI) {
+			t.Errorf("%q: expected ErrInvalidURI, got %v", uri, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package strength
+
+import (
+	"math"
+	"strings"
+	"sync"
+)
+
+// A keyboard layout for finding keyboard patterns like qwerty or zxcvbn
+type keyboard struct {
+	name string
+	//Keys of each row separated by spaces, each key its unshifted then its shifted character.
+	//An empty key leaves a gap.
+	rows []string
+	//Position of the first key of each row, in key widths
+	offsets []float64
+	//Rows of a typewriter keyboard are offset from each other, keypads are aligned
+	slanted bool
+}
+
+// Where a character sits on a keyboard
+type keyPosition struct {
+	x, y    float64
+	shifted bool
+}
+
+// A keyboard with the positions of its characters
+type keyboardGraph struct {
+	name      string
+	positions map[rune]keyPosition
+	slanted   bool
+	//Number of keys and the average number of neighbours of a key, for guess estimates
+	startingPositions float64
+	averageDegree     float64
+}
+
+var keyboards = []keyboard{
+	{
+		name: "qwerty",
+		rows: []string{
+			"`~ 1! 2@ 3# 4$ 5% 6^ 7& 8* 9( 0) -_ =+",
+			"qQ wW eE rR tT yY uU iI oO pP [{ ]} \\|",
+			"aA sS dD fF gG hH jJ kK lL ;: '\"",
+			"zZ xX cC vV bB nN mM ,< .> /?",
+		},
+		offsets: []float64{0, 1.5, 1.75, 2.25},
+		slanted: true,
+	},
+	{
+		name: "dvorak",
+		rows: []string{
+			"`~ 1! 2@ 3# 4$ 5% 6^ 7& 8* 9( 0) [{ ]}",
+			"'\" ,< .> pP yY fF gG cC rR lL /? =+ \\|",
+			"aA oO eE uU iI dD hH tT nN sS -_",
+			";: qQ jJ kK xX bB mM wW vV zZ",
+		},
+		offsets: []float64{0, 1.5, 1.75, 2.25},
+		slanted: true,
+	},
+	{
+		name: "keypad",
+		rows: []string{
+			" / * -",
+			"7 8 9 +",
+			"4 5 6",
+			"1 2 3",
+			"0  .",
+		},
+		offsets: []float64{0, 0, 0, 0, 0},
+	},
+}
+
+var keyboardGraphs = sync.OnceValue(func() []*keyboardGraph {
+	graphs := make([]*keyboardGraph, len(keyboards))
+	for i, layout := range keyboards {
+		graphs[i] = layout.graph()
+	}
+	return graphs
+})
+
+func (layout keyboard) graph() *keyboardGraph {
+	graph := &keyboardGraph{name: layout.name, positions: map[rune]keyPosition{}, slanted: layout.slanted}
+	var keys []keyPosition
+	for y, row := range layout.rows {
+		for x, key := range strings.Split(row, " ") {
+			position := keyPosition{x: layout.offsets[y] + float64(x), y: float64(y)}
+			for i, char := range key {
+				graph.positions[char] = keyPosition{x: position.x, y: position.y, shifted: i > 0}
+			}
+			if key != "" {
+				keys = append(keys, position)
+			}
+		}
+	}
+
+	var degrees float64
+	for _, a := range keys {
+		for _, b := range keys {
+			if graph.adjacent(a, b) {
+				degrees++
+			}
+		}
+	}
+	graph.startingPositions = float64(len(keys))
+	graph.averageDegree = degrees / float64(len(keys))
+	return graph
+}
+
+// Whether two different keys touch. On a slanted keyboard a key touches the keys beside it and
+// the keys of the rows above and below that overlap it.
+func (graph *keyboardGraph) adjacent(a keyPosition, b keyPosition) bool {
+	dx, dy := math.Abs(a.x-b.x), math.Abs(a.y-b.y)
+	if dx == 0 && dy == 0 {
+		return false
+	}
+	if graph.slanted {
+		return (dy == 0 && dx == 1) || (dy == 1 && dx < 1)
+	}
+	return dx <= 1 && dy <= 1
+}
+
+// Direction from the key of a to the key of b, to count the turns of a pattern
+func direction(a keyPosition, b keyPosition) [2]float64 {
+	return [2]float64{math.Copysign(1, b.x-a.x) * math.Ceil(math.Abs(b.x-a.x)), b.y - a.y}
+}
@@ -0,0 +1,511 @@
+package strength
+
+import (
+	_ "embed"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Kinds of patterns found in passwords
+const (
+	patternDictionary = "dictionary"
+	patternSpatial    = "spatial"
+	patternRepeat     = "repeat"
+	patternSequence   = "sequence"
+	patternYear       = "year"
+	patternDate       = "date"
+	patternBruteforce = "bruteforce"
+)
+
+// Frequency lists, most common first
+const (
+	dictionaryPasswords   = "passwords"
+	dictionaryEnglish     = "english"
+	dictionaryFemaleNames = "female_names"
+	dictionaryMaleNames   = "male_names"
+	dictionarySurnames    = "surnames"
+	dictionaryUserInputs  = "user_inputs"
+)
+
+// Longest sequence step, e.g. 2 for "acegi"
+const maxSequenceDelta int = 5
+
+//Frequency lists from zxcvbn, see lists/LICENSE
+
+//go:embed lists/passwords.txt
+var passwordsList string
+
+//go:embed lists/english.txt
+var englishList string
+
+//go:embed lists/female_names.txt
+var femaleNamesList string
+
+//go:embed lists/male_names.txt
+var maleNamesList string
+
+//go:embed lists/surnames.txt
+var surnamesList string
+
+// Rank of each word by list, 1 for the most common
+var rankedDictionaries = sync.OnceValue(func() map[string]map[string]int {
+	return map[string]map[string]int{
+		dictionaryPasswords:   rankWords(strings.Split(strings.TrimSpace(passwordsList), "\n")),
+		dictionaryEnglish:     rankWords(strings.Split(strings.TrimSpace(englishList), "\n")),
+		dictionaryFemaleNames: rankWords(strings.Split(strings.TrimSpace(femaleNamesList), "\n")),
+		dictionaryMaleNames:   rankWords(strings.Split(strings.TrimSpace(maleNamesList), "\n")),
+		dictionarySurnames:    rankWords(strings.Split(strings.TrimSpace(surnamesList), "\n")),
+	}
+})
+
+// Letters that l33t speak replaces with each character
+var l33tTable = map[rune][]rune{
+	'4': {'a'}, '@': {'a'}, '8': {'b'}, '(': {'c'}, '{': {'c'}, '[': {'c'}, '<': {'c'},
+	'3': {'e'}, '6': {'g'}, '9': {'g'}, '1': {'i', 'l'}, '!': {'i'}, '|': {'i', 'l'},
+	'7': {'l', 't'}, '0': {'o'}, '$': {'s'}, '5': {'s'}, '+': {'t'}, '%': {'x'}, '2': {'z'},
+}
+
+// Most l33t readings of one password that are tried
+const maxL33tReadings int = 64
+
+var (
+	recentYear    = regexp.MustCompile(`19\d\d|20\d\d`)
+	separatedDate = regexp.MustCompile(`^(\d{1,4})([\s/\\_.-])(\d{1,2})([\s/\\_.-])(\d{1,4})$`)
+)
+
+// A pattern found at password[i:j+1]
+type match struct {
+	pattern string
+	i, j    int
+	token   string
+	guesses float64
+
+	//Dictionary matches
+	dictionary string
+	rank       int
+	reversed   bool
+	l33t       bool
+	//L33t characters of the token and the letters they stand for
+	substitutions map[rune]rune
+
+	//Keyboard patterns
+	graph   *keyboardGraph
+	turns   int
+	shifted int
+
+	//Repeats
+	baseToken   string
+	baseGuesses float64
+	repeatCount int
+
+	//Sequences
+	ascending bool
+
+	//Years and dates
+	year      int
+	separator string
+}
+
+func rankWords(words []string) map[string]int {
+	ranks := make(map[string]int, len(words))
+	for i, word := range words {
+		ranks[word] = i + 1
+	}
+	return ranks
+}
+
+// Every pattern found in password, sorted by position
+func findMatches(password []rune, userInputs []string) []*match {
+	dictionaries := rankedDictionaries()
+	if len(userInputs) > 0 {
+		inputs := map[string]int{}
+		for i, input := range userInputs {
+			if input = strings.ToLower(input); input != "" {
+				inputs[input] = i + 1
+			}
+		}
+		dictionaries = withDictionary(dictionaries, dictionaryUserInputs, inputs)
+	}
+
+	var matches []*match
+	matches = append(matches, dictionaryMatches(password, dictionaries)...)
+	matches = append(matches, reversedDictionaryMatches(password, dictionaries)...)
+	matches = append(matches, l33tMatches(password, dictionaries)...)
+	matches = append(matches, spatialMatches(password)...)
+	matches = append(matches, repeatMatches(password, dictionaries)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, yearMatches(password)...)
+	matches = append(matches, dateMatches(password)...)
+	slices.SortFunc(matches, func(a *match, b *match) int {
+		if a.i != b.i {
+			return a.i - b.i
+		}
+		return a.j - b.j
+	})
+	return matches
+}
+
+// Copy of dictionaries with one more added, so the shared lists are never changed
+func withDictionary(dictionaries map[string]map[string]int, name string, words map[string]int) map[string]map[string]int {
+	copied := make(map[string]map[string]int, len(dictionaries)+1)
+	for key, value := range dictionaries {
+		copied[key] = value
+	}
+	copied[name] = words
+	return copied
+}
+
+func lower(password []rune) []rune {
+	lowered := make([]rune, len(password))
+	for i, r := range password {
+		lowered[i] = unicode.ToLower(r)
+	}
+	return lowered
+}
+
+// Every substring that is a word of a dictionary, ignoring case
+func dictionaryMatches(password []rune, dictionaries map[string]map[string]int) []*match {
+	lowered := lower(password)
+	var matches []*match
+	for i := range password {
+		for j := i; j < len(password); j++ {
+			word := string(lowered[i : j+1])
+			for name, ranks := range dictionaries {
+				if rank, ok := ranks[word]; ok {
+					matches = append(matches, &match{
+						pattern:    patternDictionary,
+						i:          i,
+						j:          j,
+						token:      string(password[i : j+1]),
+						dictionary: name,
+						rank:       rank,
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// Words written backwards, like "drowssap"
+func reversedDictionaryMatches(password []rune, dictionaries map[string]map[string]int) []*match {
+	reversed := slices.Clone(password)
+	slices.Reverse(reversed)
+	matches := dictionaryMatches(reversed, dictionaries)
+	for _, m := range matches {
+		m.i, m.j = len(password)-1-m.j, len(password)-1-m.i
+		m.token = string(password[m.i : m.j+1])
+		m.reversed = true
+	}
+	return matches
+}
+
+// Words with letters swapped for look-alikes, like "p4ssw0rd"
+func l33tMatches(password []rune, dictionaries map[string]map[string]int) []*match {
+	lowered := lower(password)
+	var matches []*match
+	for _, reading := range l33tReadings(lowered) {
+		translated := make([]rune, len(lowered))
+		for i, r := range lowered {
+			if letter, ok := reading[r]; ok {
+				translated[i] = letter
+			} else {
+				translated[i] = r
+			}
+		}
+		for _, m := range dictionaryMatches(translated, dictionaries) {
+			if m.i == m.j {
+				continue
+			}
+			substitutions := map[rune]rune{}
+			for _, r := range lowered[m.i : m.j+1] {
+				if letter, ok := reading[r]; ok {
+					substitutions[r] = letter
+				}
+			}
+			if len(substitutions) == 0 {
+				continue
+			}
+			m.token = string(password[m.i : m.j+1])
+			m.l33t = true
+			m.substitutions = substitutions
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// Ways to read the l33t characters of password, each mapping a character to one letter
+func l33tReadings(password []rune) []map[rune]rune {
+	var present []rune
+	for r := range l33tTable {
+		if slices.Contains(password, r) {
+			present = append(present, r)
+		}
+	}
+	if len(present) == 0 {
+		return nil
+	}
+	slices.Sort(present)
+
+	readings := []map[rune]rune{{}}
+	for _, r := range present {
+		var next []map[rune]rune
+	extend:
+		for _, reading := range readings {
+			for _, letter := range l33tTable[r] {
+				if len(next) == maxL33tReadings {
+					break extend
+				}
+				extended := map[rune]rune{r: letter}
+				for k, v := range reading {
+					extended[k] = v
+				}
+				next = append(next, extended)
+			}
+		}
+		readings = next
+	}
+	return readings
+}
+
+// Runs of at least three neighbouring keys on any keyboard, like "qwerty" or "zxcvbn"
+func spatialMatches(password []rune) []*match {
+	var matches []*match
+	for _, graph := range keyboardGraphs() {
+		for i := 0; i < len(password)-2; {
+			j, turns, shifted := i, 0, 0
+			var lastDirection [2]float64
+			if position, ok := graph.positions[password[i]]; ok && position.shifted {
+				shifted++
+			}
+			for j+1 < len(password) {
+				from, okFrom := graph.positions[password[j]]
+				to, okTo := graph.positions[password[j+1]]
+				if !okFrom || !okTo || !graph.adjacent(from, to) {
+					break
+				}
+				if d := direction(from, to); j == i || d != lastDirection {
+					turns++
+					lastDirection = d
+				}
+				if to.shifted {
+					shifted++
+				}
+				j++
+			}
+			if j-i >= 2 {
+				matches = append(matches, &match{
+					pattern: patternSpatial,
+					i:       i,
+					j:       j,
+					token:   string(password[i : j+1]),
+					graph:   graph,
+					turns:   turns,
+					shifted: shifted,
+				})
+				i = j
+			} else {
+				i++
+			}
+		}
+	}
+	return matches
+}
+
+// A string repeated at least twice, like "aaa" or "abcabc". The repeated unit is scored like a
+// password of its own.
+func repeatMatches(password []rune, dictionaries map[string]map[string]int) []*match {
+	var matches []*match
+	for i := 0; i < len(password)-1; {
+		bestLength, bestCount := 0, 0
+		for length := 1; i+2*length <= len(password); length++ {
+			count := 1
+			for i+(count+1)*length <= len(password) && slices.Equal(password[i:i+length], password[i+count*length:i+(count+1)*length]) {
+				count++
+			}
+			if count > 1 && length*count > bestLength*bestCount {
+				bestLength, bestCount = length, count
+			}
+		}
+		if bestCount == 0 {
+			i++
+			continue
+		}
+		base := password[i : i+bestLength]
+		_, baseGuesses := mostGuessableSequence(base, findBaseMatches(base, dictionaries))
+		end := i + bestLength*bestCount - 1
+		matches = append(matches, &match{
+			pattern:     patternRepeat,
+			i:           i,
+			j:           end,
+			token:       string(password[i : end+1]),
+			baseToken:   string(base),
+			baseGuesses: baseGuesses,
+			repeatCount: bestCount,
+		})
+		i = end + 1
+	}
+	return matches
+}
+
+// Matches within the unit of a repeat, which cannot repeat itself
+func findBaseMatches(base []rune, dictionaries map[string]map[string]int) []*match {
+	var matches []*match
+	matches = append(matches, dictionaryMatches(base, dictionaries)...)
+	matches = append(matches, reversedDictionaryMatches(base, dictionaries)...)
+	matches = append(matches, l33tMatches(base, dictionaries)...)
+	matches = append(matches, spatialMatches(base)...)
+	matches = append(matches, sequenceMatches(base)...)
+	matches = append(matches, yearMatches(base)...)
+	matches = append(matches, dateMatches(base)...)
+	return matches
+}
+
+// Runs of at least three characters with a constant step, like "abc", "9753" or "acegi"
+func sequenceMatches(password []rune) []*match {
+	var matches []*match
+	for i := 0; i < len(password)-2; {
+		delta := int(password[i+1] - password[i])
+		if delta == 0 || delta > maxSequenceDelta || delta < -maxSequenceDelta {
+			i++
+			continue
+		}
+		j := i + 1
+		for j+1 < len(password) && int(password[j+1]-password[j]) == delta {
+			j++
+		}
+		if j-i >= 2 {
+			matches = append(matches, &match{
+				pattern:   patternSequence,
+				i:         i,
+				j:         j,
+				token:     string(password[i : j+1]),
+				ascending: delta > 0,
+			})
+			i = j
+		} else {
+			i++
+		}
+	}
+	return matches
+}
+
+// Four digit years from 1900 to 2099
+func yearMatches(password []rune) []*match {
+	var matches []*match
+	text := string(password)
+	for _, loc := range recentYear.FindAllStringIndex(text, -1) {
+		i := len([]rune(text[:loc[0]]))
+		year, _ := strconv.Atoi(text[loc[0]:loc[1]])
+		matches = append(matches, &match{pattern: patternYear, i: i, j: i + 3, token: text[loc[0]:loc[1]], year: year})
+	}
+	return matches
+}
+
+// Dates of four to eight digits, like 13051991 or 5891, or with separators, like 13/5/91
+func dateMatches(password []rune) []*match {
+	var matches []*match
+	for i := range password {
+		for j := i + 3; j < len(password) && j < i+10; j++ {
+			token := string(password[i : j+1])
+			var year int
+			var ok bool
+			separator := ""
+			if parts := separatedDate.FindStringSubmatch(token); parts != nil {
+				if parts[2] != parts[4] {
+					continue
+				}
+				separator = parts[2]
+				year, ok = dateYear([]string{parts[1], parts[3], parts[5]})
+			} else if j-i < 8 && isDigits(token) {
+				year, ok = splitDate(token)
+			}
+			if ok {
+				matches = append(matches, &match{pattern: patternDate, i: i, j: j, token: token, year: year, separator: separator})
+			}
+		}
+	}
+	return matches
+}
+
+func isDigits(token string) bool {
+	for _, r := range token {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Tries every way to cut a digit string into day, month and year and returns the year closest
+// to now
+func splitDate(token string) (int, bool) {
+	best, found := 0, false
+	for first := 1; first < len(token)-1; first++ {
+		for second := first + 1; second < len(token); second++ {
+			if year, ok := dateYear([]string{token[:first], token[first:second], token[second:]}); ok && (!found || closerYear(year, best)) {
+				best, found = year, true
+			}
+		}
+	}
+	return best, found
+}
+
+// Year of a date given as three numbers in day-month-year, month-day-year or year-month-day
+// order, picking the reading closest to now
+func dateYear(parts []string) (int, bool) {
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		if len(part) > 4 || len(part) == 3 {
+			return 0, false
+		}
+		numbers[i], _ = strconv.Atoi(part)
+	}
+	best, found := 0, false
+	consider := func(yearPart string, year int, a int, b int) {
+		if !validDayMonth(a, b) && !validDayMonth(b, a) {
+			return
+		}
+		if len(yearPart) == 2 {
+			year = twoDigitYear(year)
+		} else if len(yearPart) != 4 || year < 1000 || year > 2050 {
+			return
+		}
+		if !found || closerYear(year, best) {
+			best, found = year, true
+		}
+	}
+	consider(parts[2], numbers[2], numbers[0], numbers[1])
+	consider(parts[0], numbers[0], numbers[1], numbers[2])
+	return best, found
+}
+
+func validDayMonth(day int, month int) bool {
+	return day >= 1 && day <= 31 && month >= 1 && month <= 12
+}
+
+// 19xx for 50 and later, 20xx otherwise
+func twoDigitYear(year int) int {
+	if year >= 50 {
+		return 1900 + year
+	}
+	return 2000 + year
+}
+
+func closerYear(a int, b int) bool {
+	now := time.Now().Year()
+	return abs(a-now) < abs(b-now)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
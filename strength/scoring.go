@@ -0,0 +1,270 @@
+package strength
+
+import (
+	"math"
+	"time"
+	"unicode"
+)
+
+// Guess estimates, from zxcvbn
+const (
+	//Guesses per character of a stretch that matches no pattern
+	BRUTEFORCE_CARDINALITY float64 = 10
+	//Least guesses for a pattern shorter than the whole password, so splitting a password into
+	//many tiny patterns never looks cheaper than it is
+	MIN_SUBMATCH_GUESSES_SINGLE_CHAR float64 = 10
+	MIN_SUBMATCH_GUESSES_MULTI_CHAR  float64 = 50
+	//Penalty for each extra pattern in a password, as attackers try few patterns first
+	MIN_GUESSES_BEFORE_GROWING_SEQUENCE float64 = 10000
+	//Least span of years an attacker tries around now
+	MIN_YEAR_SPACE float64 = 20
+)
+
+// Finds the cover of password by matches and random stretches that takes the fewest guesses,
+// as zxcvbn does: an attacker tries l patterns in any order, l! times the product of their
+// guesses, plus a penalty growing with l.
+func mostGuessableSequence(password []rune, matches []*match) ([]*match, float64) {
+	n := len(password)
+	if n == 0 {
+		return nil, 1
+	}
+	byEnd := make([][]*match, n)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	//Best last match, product of guesses and total guesses of sequences of l matches ending at k
+	best := make([]map[int]*match, n)
+	products := make([]map[int]float64, n)
+	totals := make([]map[int]float64, n)
+	for k := range best {
+		best[k], products[k], totals[k] = map[int]*match{}, map[int]float64{}, map[int]float64{}
+	}
+
+	update := func(m *match, l int) {
+		k := m.j
+		product := estimateGuesses(m, n)
+		if l > 1 {
+			product *= products[m.i-1][l-1]
+		}
+		total := factorial(l)*product + math.Pow(MIN_GUESSES_BEFORE_GROWING_SEQUENCE, float64(l-1))
+		//Longer sequences must beat every shorter one that ends here
+		for other, otherTotal := range totals[k] {
+			if other <= l && otherTotal <= total {
+				return
+			}
+		}
+		best[k][l], products[k][l], totals[k][l] = m, product, total
+	}
+	bruteforceUpdate := func(k int) {
+		update(bruteforceMatch(password, 0, k), 1)
+		for i := 1; i <= k; i++ {
+			m := bruteforceMatch(password, i, k)
+			for l, last := range best[i-1] {
+				//Two random stretches in a row are one longer stretch
+				if last.pattern != patternBruteforce {
+					update(m, l+1)
+				}
+			}
+		}
+	}
+
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			if m.i > 0 {
+				for l := range best[m.i-1] {
+					update(m, l+1)
+				}
+			} else {
+				update(m, 1)
+			}
+		}
+		bruteforceUpdate(k)
+	}
+
+	//Walk back from the cheapest sequence covering the whole password
+	bestLength, bestTotal := 0, math.Inf(1)
+	for l, total := range totals[n-1] {
+		if total < bestTotal {
+			bestLength, bestTotal = l, total
+		}
+	}
+	sequence := make([]*match, bestLength)
+	for k, l := n-1, bestLength; l > 0; l-- {
+		m := best[k][l]
+		sequence[l-1] = m
+		k = m.i - 1
+	}
+	return sequence, bestTotal
+}
+
+func bruteforceMatch(password []rune, i int, j int) *match {
+	return &match{pattern: patternBruteforce, i: i, j: j, token: string(password[i : j+1])}
+}
+
+// Guesses for one match within a password of passwordLength characters
+func estimateGuesses(m *match, passwordLength int) float64 {
+	if m.guesses > 0 {
+		return m.guesses
+	}
+	length := m.j - m.i + 1
+	minimum := 1.0
+	if length < passwordLength {
+		minimum = MIN_SUBMATCH_GUESSES_MULTI_CHAR
+		if length == 1 {
+			minimum = MIN_SUBMATCH_GUESSES_SINGLE_CHAR
+		}
+	}
+
+	var guesses float64
+	switch m.pattern {
+	case patternBruteforce:
+		guesses = math.Pow(BRUTEFORCE_CARDINALITY, float64(length))
+		//A random stretch is never cheaper than the smallest pattern
+		guesses = math.Max(guesses, MIN_SUBMATCH_GUESSES_SINGLE_CHAR+1)
+		if length > 1 {
+			guesses = math.Max(guesses, MIN_SUBMATCH_GUESSES_MULTI_CHAR+1)
+		}
+	case patternDictionary:
+		guesses = float64(m.rank) * uppercaseVariations(m.token) * l33tVariations(m)
+		if m.reversed {
+			guesses *= 2
+		}
+	case patternSpatial:
+		guesses = spatialGuesses(m)
+	case patternRepeat:
+		guesses = m.baseGuesses * float64(m.repeatCount)
+	case patternSequence:
+		guesses = sequenceGuesses(m)
+	case patternYear:
+		guesses = yearSpace(m.year)
+	case patternDate:
+		guesses = yearSpace(m.year) * 365
+		if m.separator != "" {
+			guesses *= 4
+		}
+	}
+	m.guesses = math.Max(guesses, minimum)
+	return m.guesses
+}
+
+func yearSpace(year int) float64 {
+	return math.Max(math.Abs(float64(year-time.Now().Year())), MIN_YEAR_SPACE)
+}
+
+// Ways to capitalize a word that an attacker tries: all lower, first or last letter, all
+// upper, else any choice of the same number of upper case letters
+func uppercaseVariations(token string) float64 {
+	runes := []rune(token)
+	upper, lowers := 0, 0
+	for _, r := range runes {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lowers++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	first, last := unicode.IsUpper(runes[0]), unicode.IsUpper(runes[len(runes)-1])
+	if lowers == 0 || (upper == 1 && (first || last)) {
+		return 2
+	}
+	var variations float64
+	for i := 1; i <= min(upper, lowers); i++ {
+		variations += binomial(upper+lowers, i)
+	}
+	return variations
+}
+
+// Ways to apply the l33t substitutions of a match to a word of its length
+func l33tVariations(m *match) float64 {
+	if !m.l33t {
+		return 1
+	}
+	variations := 1.0
+	for l33t, letter := range m.substitutions {
+		substituted, unsubstituted := 0, 0
+		for _, r := range []rune(m.token) {
+			switch unicode.ToLower(r) {
+			case l33t:
+				substituted++
+			case letter:
+				unsubstituted++
+			}
+		}
+		if unsubstituted == 0 {
+			//Every such letter was replaced, one extra guess per substitution
+			variations *= 2
+			continue
+		}
+		var possibilities float64
+		for i := 1; i <= min(substituted, unsubstituted); i++ {
+			possibilities += binomial(substituted+unsubstituted, i)
+		}
+		variations *= possibilities
+	}
+	return variations
+}
+
+// Starting keys times the ways to walk the pattern's length with its number of turns
+func spatialGuesses(m *match) float64 {
+	length := m.j - m.i + 1
+	var guesses float64
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= min(m.turns, i-1); j++ {
+			guesses += binomial(i-1, j-1) * m.graph.startingPositions * math.Pow(m.graph.averageDegree, float64(j))
+		}
+	}
+	if m.shifted > 0 {
+		unshifted := length - m.shifted
+		if unshifted == 0 {
+			guesses *= 2
+		} else {
+			var variations float64
+			for i := 1; i <= min(m.shifted, unshifted); i++ {
+				variations += binomial(m.shifted+unshifted, i)
+			}
+			guesses *= variations
+		}
+	}
+	return guesses
+}
+
+// Obvious starts like a, z, 0, 1 or 9 are tried first, descending sequences count double
+func sequenceGuesses(m *match) float64 {
+	first := []rune(m.token)[0]
+	var base float64
+	switch {
+	case first == 'a' || first == 'A' || first == 'z' || first == 'Z' || first == '0' || first == '1' || first == '9':
+		base = 4
+	case unicode.IsDigit(first):
+		base = 10
+	default:
+		base = 26
+	}
+	if !m.ascending {
+		base *= 2
+	}
+	return base * float64(len([]rune(m.token)))
+}
+
+func binomial(n int, k int) float64 {
+	if k > n {
+		return 0
+	}
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+func factorial(n int) float64 {
+	result := 1.0
+	for i := 2; i <= n; i++ {
+		result *= float64(i)
+	}
+	return result
+}
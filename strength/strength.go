@@ -0,0 +1,177 @@
+package strength
+
+import (
+	"math"
+	"unicode"
+)
+
+// Scores from 0 (guessed within a thousand tries) to 4 (more than ten billion tries)
+const (
+	SCORE_TOO_GUESSABLE int = iota
+	SCORE_VERY_GUESSABLE
+	SCORE_SOMEWHAT_GUESSABLE
+	SCORE_SAFELY_UNGUESSABLE
+	SCORE_VERY_UNGUESSABLE
+)
+
+// Only this many characters are searched for patterns, the rest count as random characters.
+// Longer passwords are strong anyway and the search grows with the square of the length.
+const MAX_ANALYZED_LENGTH int = 100
+
+// How hard a password is to guess
+type Result struct {
+	//SCORE_TOO_GUESSABLE to SCORE_VERY_UNGUESSABLE
+	Score int `json:"score"`
+	//Estimated number of guesses an attacker who knows common patterns needs
+	Guesses      float64  `json:"guesses"`
+	GuessesLog10 float64  `json:"guessesLog10"`
+	Feedback     Feedback `json:"feedback"`
+}
+
+// What makes a password weak and how to improve it. Empty for strong passwords.
+type Feedback struct {
+	Warning     string   `json:"warning,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Estimates how many guesses password takes when attackers try common passwords, words,
+// names, keyboard patterns, repeats, sequences and dates before random strings, in the manner
+// of zxcvbn. userInputs such as the username or email count as the most likely words.
+func Estimate(password string, userInputs ...string) Result {
+	runes := []rune(password)
+	var rest float64 = 1
+	if len(runes) > MAX_ANALYZED_LENGTH {
+		rest = math.Pow(BRUTEFORCE_CARDINALITY, float64(len(runes)-MAX_ANALYZED_LENGTH))
+		runes = runes[:MAX_ANALYZED_LENGTH]
+	}
+
+	sequence, guesses := mostGuessableSequence(runes, findMatches(runes, userInputs))
+	guesses *= rest
+	result := Result{
+		Score:        score(guesses),
+		Guesses:      guesses,
+		GuessesLog10: math.Log10(guesses),
+	}
+	result.Feedback = feedback(result.Score, sequence)
+	return result
+}
+
+// zxcvbn's thresholds: a few guesses of slack keep borderline results from jumping a score
+func score(guesses float64) int {
+	const delta = 5
+	switch {
+	case guesses < 1e3+delta:
+		return SCORE_TOO_GUESSABLE
+	case guesses < 1e6+delta:
+		return SCORE_VERY_GUESSABLE
+	case guesses < 1e8+delta:
+		return SCORE_SOMEWHAT_GUESSABLE
+	case guesses < 1e10+delta:
+		return SCORE_SAFELY_UNGUESSABLE
+	}
+	return SCORE_VERY_UNGUESSABLE
+}
+
+// Advice based on the longest pattern found in a weak password
+func feedback(score int, sequence []*match) Feedback {
+	if len(sequence) == 0 {
+		return Feedback{Suggestions: []string{
+			"Use a few words, avoid common phrases",
+			"No need for symbols, digits, or uppercase letters",
+		}}
+	}
+	if score > SCORE_SOMEWHAT_GUESSABLE {
+		return Feedback{}
+	}
+
+	longest := sequence[0]
+	for _, m := range sequence[1:] {
+		if len(m.token) > len(longest.token) {
+			longest = m
+		}
+	}
+	result := matchFeedback(longest, len(sequence) == 1)
+	result.Suggestions = append([]string{"Add another word or two. Uncommon words are better."}, result.Suggestions...)
+	return result
+}
+
+func matchFeedback(m *match, sole bool) Feedback {
+	switch m.pattern {
+	case patternDictionary:
+		return dictionaryFeedback(m, sole)
+	case patternSpatial:
+		warning := "Short keyboard patterns are easy to guess"
+		if m.turns == 1 {
+			warning = "Straight rows of keys are easy to guess"
+		}
+		return Feedback{Warning: warning, Suggestions: []string{"Use a longer keyboard pattern with more turns"}}
+	case patternRepeat:
+		warning := `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`
+		if len([]rune(m.baseToken)) == 1 {
+			warning = `Repeats like "aaa" are easy to guess`
+		}
+		return Feedback{Warning: warning, Suggestions: []string{"Avoid repeated words and characters"}}
+	case patternSequence:
+		return Feedback{Warning: "Sequences like abc or 6543 are easy to guess", Suggestions: []string{"Avoid sequences"}}
+	case patternYear:
+		return Feedback{Warning: "Recent years are easy to guess", Suggestions: []string{"Avoid recent years", "Avoid years that are associated with you"}}
+	case patternDate:
+		return Feedback{Warning: "Dates are often easy to guess", Suggestions: []string{"Avoid dates and years that are associated with you"}}
+	}
+	return Feedback{}
+}
+
+func dictionaryFeedback(m *match, sole bool) Feedback {
+	var result Feedback
+	switch m.dictionary {
+	case dictionaryPasswords:
+		switch {
+		case sole && !m.l33t && !m.reversed && m.rank <= 10:
+			result.Warning = "This is a top-10 common password"
+		case sole && !m.l33t && !m.reversed && m.rank <= 100:
+			result.Warning = "This is a top-100 common password"
+		case sole && !m.l33t && !m.reversed:
+			result.Warning = "This is a very common password"
+		case math.Log10(m.guesses) <= 4:
+			result.Warning = "This is similar to a commonly used password"
+		}
+	case dictionaryEnglish:
+		if sole {
+			result.Warning = "A word by itself is easy to guess"
+		}
+	case dictionarySurnames, dictionaryMaleNames, dictionaryFemaleNames:
+		result.Warning = "Common names and surnames are easy to guess"
+		if sole {
+			result.Warning = "Names and surnames by themselves are easy to guess"
+		}
+	case dictionaryUserInputs:
+		result.Warning = "Your username or email is easy to guess"
+	}
+
+	runes := []rune(m.token)
+	switch {
+	case len(runes) > 1 && isUpper(runes):
+		result.Suggestions = append(result.Suggestions, "All-uppercase is almost as easy to guess as all-lowercase")
+	case unicode.IsUpper(runes[0]):
+		result.Suggestions = append(result.Suggestions, "Capitalization doesn't help very much")
+	}
+	if m.reversed && len(runes) >= 4 {
+		result.Suggestions = append(result.Suggestions, "Reversed words aren't much harder to guess")
+	}
+	if m.l33t {
+		result.Suggestions = append(result.Suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+	}
+	return result
+}
+
+// Has upper case letters and no lower case ones
+func isUpper(runes []rune) bool {
+	upper := false
+	for _, r := range runes {
+		if unicode.IsLower(r) {
+			return false
+		}
+		upper = upper || unicode.IsUpper(r)
+	}
+	return upper
+}
@@ -0,0 +1,79 @@
+package strength
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	t.Run("Scores", func(t *testing.T) {
+		cases := []struct {
+			password string
+			maxScore int
+			minScore int
+		}{
+			{"password", 0, 0},
+			{"P@ssw0rd", 0, 0},
+			{"drowssap", 0, 0},
+			{"qwerty", 0, 0},
+			{"aaaaaaaa", 0, 0},
+			{"abcdefgh", 0, 0},
+			{"13/05/1991", 1, 0},
+			{"correct horse battery staple", 4, 4},
+			{"kX9#mQ2$vL7@pR4!", 4, 4},
+		}
+		for _, c := range cases {
+			result := Estimate(c.password)
+			if result.Score < c.minScore || result.Score > c.maxScore {
+				t.Errorf("%q scored %d, expected %d to %d", c.password, result.Score, c.minScore, c.maxScore)
+			}
+		}
+	})
+
+	t.Run("Patterns", func(t *testing.T) {
+		cases := map[string]string{
+			"monkey":     "common password",
+			"Tr0mbone":   "Predictable substitutions",
+			"zxcvbnm":    "common password",
+			"rtyfgh":     "keyboard",
+			"abcabcabc":  "Repeats",
+			"98765":      "Sequences",
+			"2019":       "Recent years",
+			"19-07-2001": "Dates",
+		}
+		for password, expected := range cases {
+			result := Estimate(password)
+			text := result.Feedback.Warning + " " + strings.Join(result.Feedback.Suggestions, " ")
+			if !strings.Contains(text, expected) {
+				t.Errorf("%q: expected feedback about %q, got %+v", password, expected, result.Feedback)
+			}
+		}
+	})
+
+	t.Run("User inputs", func(t *testing.T) {
+		plain := Estimate("zebulonski")
+		named := Estimate("zebulonski", "Zebulonski", "zeb@example.com")
+		if named.Guesses >= plain.Guesses || named.Feedback.Warning == "" {
+			t.Errorf("A password equal to the username should be weaker, got %+v and %+v", plain, named)
+		}
+	})
+
+	t.Run("More characters cost more guesses", func(t *testing.T) {
+		previous := Estimate("")
+		for _, password := range []string{"x", "xk", "xk7", "xk7#", "xk7#q"} {
+			result := Estimate(password)
+			if result.Guesses <= previous.Guesses {
+				t.Errorf("%q takes %f guesses, not more than %f", password, result.Guesses, previous.Guesses)
+			}
+			previous = result
+		}
+	})
+
+	t.Run("Long passwords", func(t *testing.T) {
+		analyzed := Estimate(strings.Repeat("a", MAX_ANALYZED_LENGTH))
+		result := Estimate(strings.Repeat("a", MAX_ANALYZED_LENGTH) + "ab")
+		if result.Guesses != analyzed.Guesses*BRUTEFORCE_CARDINALITY*BRUTEFORCE_CARDINALITY {
+			t.Errorf("Characters past the analyzed length should count as random, got %f and %f", analyzed.Guesses, result.Guesses)
+		}
+	})
+}
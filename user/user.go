@@ -1,6 +1,7 @@
 package user
 
 import (
+	"PasswordManager/crypto"
 	"PasswordManager/vault"
 	"encoding/json"
 	"fmt"
@@ -14,6 +15,35 @@ const UserFileName string = "user_data.json"
 type User struct {
 	Username   string `json:"username"`
 	MasterSalt []byte `json:"master_salt"`
+	//Empty for accounts created before KDF parameters were stored
+	KDF crypto.KDFParams `json:"kdf,omitzero"`
+	//HMAC proving knowledge of the master key, empty for older accounts
+	KeyCheck []byte `json:"key_check,omitempty"`
+	//The master key also needs the user's keyfile
+	RequiresKeyfile bool `json:"requires_keyfile,omitempty"`
+	//X25519 public key others seal boxes to, the private key lives in the user's vault
+	PublicKey []byte `json:"public_key,omitempty"`
+	//Authenticator app secret checked at sign-in before the vault is unlocked. Empty when
+	//two-factor sign-in is off.
+	TOTPSecret []byte `json:"totp_secret,omitempty"`
+	//Last TOTP time step accepted, so no code is accepted twice
+	TOTPLastStep int64 `json:"totp_last_step,omitempty"`
+	//SHA-256 digests of the backup codes not used yet
+	BackupCodes [][]byte `json:"backup_codes,omitempty"`
+}
+
+// Reports whether signing in needs a TOTP or backup code
+func (user *User) HasTwoFactor() bool {
+	return len(user.TOTPSecret) > 0
+}
+
+// Returns the parameters the user's key is derived with, falling back to the legacy PBKDF2
+// settings for accounts that predate stored parameters
+func (user *User) KeyDerivation() crypto.KDFParams {
+	if user.KDF.Algorithm == "" {
+		return crypto.LegacyKDFParams()
+	}
+	return user.KDF
 }
 
 func GetAllUsers() ([]User, error) {
@@ -76,6 +106,37 @@ func SaveUser(user *User) error {
 	return nil
 }
 
+// Returns the path of the user file and its new contents with user replacing the stored
+// record of the same name. Nothing is written so the caller can commit it with other files.
+func PrepareUserUpdate(user *User) (string, []byte, error) {
+	users, err := GetAllUsers()
+	if err != nil {
+		return "", nil, fmt.Errorf("Cannot update user: %w", err)
+	}
+
+	found := false
+	for i := range users {
+		if users[i].Username == user.Username {
+			users[i] = *user
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", nil, fmt.Errorf("Cannot update user %q: user does not exist", user.Username)
+	}
+
+	bytes, err := json.Marshal(users)
+	if err != nil {
+		return "", nil, fmt.Errorf("Cannot Write user file. %w", err)
+	}
+	filePath, err := getUserFilePath()
+	if err != nil {
+		return "", nil, fmt.Errorf("Cannot update user with name %q : %w", user.Username, err)
+	}
+	return filePath, bytes, nil
+}
+
 func getUserFilePath() (string, error) {
 	appDir, err := vault.GetAppConfigDir()
 	if err != nil {
@@ -0,0 +1,140 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Marker listing the files of a commit that has been fully staged but not yet moved into place
+const commitMarkerName string = "commit.pending"
+const pendingSuffix string = ".pending"
+
+// Replaces several files as one unit. Every file is first staged next to its target, then a
+// marker is written, then the staged files are renamed into place. If the process dies after
+// the marker is written, RecoverPendingCommit finishes the renames; if it dies before, the
+// old files are left untouched.
+func CommitFiles(files map[string][]byte) error {
+	markerPath, targets, err := stageCommit(files)
+	if err != nil {
+		return err
+	}
+	return finishCommit(markerPath, targets)
+}
+
+// Stages files and writes the marker, both flushed to disk, so from here on the commit
+// survives a crash. Returns the marker path and the targets for finishCommit.
+func stageCommit(files map[string][]byte) (string, []string, error) {
+	appDir, err := GetAppConfigDir()
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not commit files. %w", err)
+	}
+
+	targets := make([]string, 0, len(files))
+	for target, data := range files {
+		if err := writeFileSynced(target+pendingSuffix, data, 0600); err != nil {
+			return "", nil, fmt.Errorf("Could not stage %q. %w", target, err)
+		}
+		targets = append(targets, target)
+	}
+	//The staged files must be on disk before a marker can point at them
+	syncTargetDirs(targets)
+
+	marker, err := json.Marshal(targets)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not commit files. %w", err)
+	}
+	markerPath := path.Join(appDir, commitMarkerName)
+	if err := writeFileSynced(markerPath+".tmp", marker, 0600); err != nil {
+		return "", nil, fmt.Errorf("Could not write commit marker. %w", err)
+	}
+	if err := os.Rename(markerPath+".tmp", markerPath); err != nil {
+		return "", nil, fmt.Errorf("Could not write commit marker. %w", err)
+	}
+	//Otherwise a crash could lose the marker after some targets were already replaced
+	syncDir(appDir)
+	return markerPath, targets, nil
+}
+
+// Completes a commit that was interrupted after its marker was written. Safe to call when
+// there is nothing to recover.
+func RecoverPendingCommit() error {
+	appDir, err := GetAppConfigDir()
+	if err != nil {
+		return fmt.Errorf("Could not recover pending commit. %w", err)
+	}
+	markerPath := path.Join(appDir, commitMarkerName)
+	marker, err := os.ReadFile(markerPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Could not read commit marker. %w", err)
+	}
+
+	var targets []string
+	if err := json.Unmarshal(marker, &targets); err != nil {
+		return fmt.Errorf("Commit marker is damaged. %w", err)
+	}
+	return finishCommit(markerPath, targets)
+}
+
+func finishCommit(markerPath string, targets []string) error {
+	for _, target := range targets {
+		err := os.Rename(target+pendingSuffix, target)
+		//Already moved by an earlier, interrupted attempt
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Could not move %q into place. %w", target, err)
+		}
+	}
+	//The renames have to be on disk before the marker that would redo them goes
+	syncTargetDirs(targets)
+
+	if err := os.Remove(markerPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Could not remove commit marker. %w", err)
+	}
+	return nil
+}
+
+func writeFileSynced(filePath string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Flushes the directories holding targets to disk, each once
+func syncTargetDirs(targets []string) {
+	synced := map[string]bool{}
+	for _, target := range targets {
+		dir := filepath.Dir(target)
+		if !synced[dir] {
+			syncDir(dir)
+			synced[dir] = true
+		}
+	}
+}
+
+// Flushes renames in dir to disk. Not every platform supports syncing a directory, so
+// failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,232 @@
+package vault
+
+import (
+	"PasswordManager/crypto"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// Vault files start with this magic, followed by a big-endian uint32 header length, the JSON
+// header and the nonce and ciphertext of the credentials. Files without it predate key slots
+// and are sealed directly under the password-derived key.
+const vaultMagic string = "PHVAULT2"
+
+// Header versions. Version 2 seals the credentials directly under the vault key, version 3
+// under its vault-encryption subkey.
+const (
+	headerVersion       int = 3
+	oldestHeaderVersion int = 2
+	subkeyHeaderVersion int = 3
+)
+
+// Kinds of key slot. Each unlock method wraps the same vault key in its own slot.
+const (
+	SlotPassword string = "password"
+	SlotRecovery string = "recovery"
+	//Wrapping key split into Shamir shares held by several people
+	SlotShares string = "shares"
+)
+
+// Plaintext header of a vault file holding the vault key wrapped by each unlock method
+type Header struct {
+	Version  int       `json:"version"`
+	KeySlots []KeySlot `json:"keySlots"`
+	//Cipher suite sealing the credentials, empty for AES256-GCM
+	Cipher string `json:"cipher,omitempty"`
+	//Number of shares needed to rebuild the key of the shares slot
+	ShareThreshold int `json:"shareThreshold,omitempty"`
+	//X25519 private key of the vault's user, nil for vaults from before key pairs
+	PrivateKey *SealedSecret `json:"privateKey,omitempty"`
+}
+
+// A secret kept in the header, sealed under a subkey of the vault key
+type SealedSecret struct {
+	Cipher     string `json:"cipher"`
+	Nonce      []byte `json:"nonce"`
+	CipherText []byte `json:"cipherText"`
+}
+
+// The vault key sealed under the key of one unlock method
+type KeySlot struct {
+	Kind string `json:"kind"`
+	//Cipher suite used to wrap, empty for AES256-GCM
+	Cipher     string `json:"cipher,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	WrappedKey []byte `json:"wrappedKey"`
+	//Wrapped under the key-wrap subkey of the unlock key. Older slots use the unlock key itself.
+	Subkey bool `json:"subkey,omitempty"`
+}
+
+func NewHeader() *Header {
+	return &Header{Version: headerVersion}
+}
+
+// Returns a deep copy so a header can be changed without touching the one in use
+func (header *Header) Clone() *Header {
+	clone := *header
+	clone.KeySlots = make([]KeySlot, len(header.KeySlots))
+	copy(clone.KeySlots, header.KeySlots)
+	return &clone
+}
+
+// Wraps vaultKey under the key-wrap subkey of wrappingKey and stores it in the slot of the
+// given kind, replacing any slot of the same kind
+func (header *Header) SetKeySlot(kind string, vaultKey []byte, wrappingKey []byte) error {
+	subkey, err := crypto.DeriveSubkey(wrappingKey, crypto.PURPOSE_KEY_WRAP)
+	if err != nil {
+		return fmt.Errorf("Could not wrap vault key. %w", err)
+	}
+	defer crypto.Wipe(subkey)
+	suite := crypto.DefaultCipherSuite
+	nonce, wrapped, err := suite.Encrypt(subkey, vaultKey)
+	if err != nil {
+		return fmt.Errorf("Could not wrap vault key. %w", err)
+	}
+	slot := KeySlot{Kind: kind, Cipher: suite.Name(), Nonce: nonce, WrappedKey: wrapped, Subkey: true}
+	for i := range header.KeySlots {
+		if header.KeySlots[i].Kind == kind {
+			header.KeySlots[i] = slot
+			return nil
+		}
+	}
+	header.KeySlots = append(header.KeySlots, slot)
+	return nil
+}
+
+// Removes the slot of the given kind, if any
+func (header *Header) RemoveKeySlot(kind string) {
+	for i := range header.KeySlots {
+		if header.KeySlots[i].Kind == kind {
+			header.KeySlots = append(header.KeySlots[:i], header.KeySlots[i+1:]...)
+			return
+		}
+	}
+}
+
+func (header *Header) HasKeySlot(kind string) bool {
+	for _, slot := range header.KeySlots {
+		if slot.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the slot of the given kind still wraps the vault key under the unlock key
+// itself, so that key serves more than one purpose until the slot is set again
+func (header *Header) HasLegacyKeySlot(kind string) bool {
+	for _, slot := range header.KeySlots {
+		if slot.Kind == kind {
+			return !slot.Subkey
+		}
+	}
+	return false
+}
+
+// Unwraps the vault key from the slot of the given kind
+func (header *Header) UnwrapKey(kind string, wrappingKey []byte) ([]byte, error) {
+	for _, slot := range header.KeySlots {
+		if slot.Kind != kind {
+			continue
+		}
+		suite, err := crypto.GetCipherSuite(slot.Cipher)
+		if err != nil {
+			return nil, fmt.Errorf("Could not unwrap vault key. %w", err)
+		}
+		if slot.Subkey {
+			subkey, err := crypto.DeriveSubkey(wrappingKey, crypto.PURPOSE_KEY_WRAP)
+			if err != nil {
+				return nil, fmt.Errorf("Could not unwrap vault key. %w", err)
+			}
+			defer crypto.Wipe(subkey)
+			wrappingKey = subkey
+		}
+		vaultKey, err := suite.Decrypt(wrappingKey, slot.Nonce, slot.WrappedKey)
+		if err != nil {
+			return nil, fmt.Errorf("Could not unwrap vault key. %w", err)
+		}
+		return vaultKey, nil
+	}
+	return nil, fmt.Errorf("Vault has no %q key slot", kind)
+}
+
+// Seals the user's X25519 private key under the private-key subkey of vaultKey
+func (header *Header) SetPrivateKey(privateKey []byte, vaultKey []byte) error {
+	subkey, err := crypto.DeriveSubkey(vaultKey, crypto.PURPOSE_PRIVATE_KEY)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(subkey)
+	suite := crypto.DefaultCipherSuite
+	nonce, cipherText, err := suite.Encrypt(subkey, privateKey)
+	if err != nil {
+		return fmt.Errorf("Could not seal private key. %w", err)
+	}
+	header.PrivateKey = &SealedSecret{Cipher: suite.Name(), Nonce: nonce, CipherText: cipherText}
+	return nil
+}
+
+// Opens the user's X25519 private key with vaultKey
+func (header *Header) OpenPrivateKey(vaultKey []byte) ([]byte, error) {
+	if header.PrivateKey == nil {
+		return nil, fmt.Errorf("Vault has no private key")
+	}
+	suite, err := crypto.GetCipherSuite(header.PrivateKey.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	subkey, err := crypto.DeriveSubkey(vaultKey, crypto.PURPOSE_PRIVATE_KEY)
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(subkey)
+	privateKey, err := suite.Decrypt(subkey, header.PrivateKey.Nonce, header.PrivateKey.CipherText)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open private key. %w", err)
+	}
+	return privateKey, nil
+}
+
+// Splits raw vault file contents into header and sealed credentials. A nil header means the
+// file is in the legacy format.
+func parseVaultFile(contents []byte) (*Header, []byte, error) {
+	if !bytes.HasPrefix(contents, []byte(vaultMagic)) {
+		return nil, contents, nil
+	}
+	rest := contents[len(vaultMagic):]
+	if len(rest) < 4 {
+		return nil, nil, fmt.Errorf("Vault header is truncated")
+	}
+	headerLen := binary.BigEndian.Uint32(rest[:4])
+	rest = rest[4:]
+	if uint64(len(rest)) < uint64(headerLen) {
+		return nil, nil, fmt.Errorf("Vault header is truncated")
+	}
+
+	var header Header
+	if err := json.Unmarshal(rest[:headerLen], &header); err != nil {
+		return nil, nil, fmt.Errorf("Vault header is damaged. %w", err)
+	}
+	if header.Version < oldestHeaderVersion || header.Version > headerVersion {
+		return nil, nil, fmt.Errorf("Unsupported vault version %d", header.Version)
+	}
+	return &header, rest[headerLen:], nil
+}
+
+// Prepends the encoded header to the sealed credentials. A nil header writes the legacy format.
+func formatVaultFile(header *Header, sealed []byte) ([]byte, error) {
+	if header == nil {
+		return sealed, nil
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("Could not encode vault header. %w", err)
+	}
+	contents := make([]byte, 0, len(vaultMagic)+4+len(headerJSON)+len(sealed))
+	contents = append(contents, vaultMagic...)
+	contents = binary.BigEndian.AppendUint32(contents, uint32(len(headerJSON)))
+	contents = append(contents, headerJSON...)
+	return append(contents, sealed...), nil
+}
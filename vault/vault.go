@@ -2,23 +2,173 @@ package vault
 
 import (
 	"PasswordManager/crypto"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 //Create Credential
 
 const appName string = "Pharoas"
-const vaultName string = "default.vault"
+
+// Vault every account shared before each user got their own, claimed by the first user to open it
+const legacyVaultName string = "default.vault"
+
+// Directory in the app directory holding the vault of each user, apart from the legacy vault
+// so that no username can name it
+const vaultsDirName string = "vaults"
+
+var (
+	ErrVaultMissing = errors.New("vault file is missing")
+	ErrVaultCorrupt = errors.New("vault file is corrupt")
+)
 
 type Credential struct {
-	ID       string `json:"id"`
-	URL      string `json:"url"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	ID       string        `json:"id"`
+	Title    string        `json:"title,omitempty"`
+	URL      string        `json:"url"`
+	Username string        `json:"username"`
+	Password string        `json:"password"`
+	Notes    string        `json:"notes,omitempty"`
+	Tags     []string      `json:"tags,omitempty"`
+	Fields   []CustomField `json:"fields,omitempty"`
+	//Date by which the password has to be changed. Zero means never.
+	RotateBy time.Time `json:"rotateBy,omitzero"`
+	//Number of days a password stays valid after it is set. Zero means no policy.
+	RotationDays int `json:"rotationDays,omitempty"`
+	//Reveal, copy and export of this item need the master password to be re-entered
+	RequireReprompt bool `json:"requireReprompt,omitempty"`
+	//When the password was last set. Zero for items saved before this was kept.
+	PasswordChangedAt time.Time `json:"passwordChangedAt,omitzero"`
+	//otpauth URI of the item's TOTP or HOTP generator. Only codes are handed out, never the seed.
+	OTP string `json:"otp,omitempty"`
+}
+
+// User defined name/value pair stored on a Credential
+type CustomField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Credential as it is sent to the browser: secrets are replaced by flags and have to be
+// fetched one at a time
+type RedactedCredential struct {
+	ID                string          `json:"id"`
+	Title             string          `json:"title,omitempty"`
+	URL               string          `json:"url"`
+	Username          string          `json:"username"`
+	HasPassword       bool            `json:"hasPassword"`
+	Notes             string          `json:"notes,omitempty"`
+	Tags              []string        `json:"tags,omitempty"`
+	Fields            []RedactedField `json:"fields,omitempty"`
+	RotateBy          time.Time       `json:"rotateBy,omitzero"`
+	RotationDays      int             `json:"rotationDays,omitempty"`
+	RequireReprompt   bool            `json:"requireReprompt,omitempty"`
+	PasswordChangedAt time.Time       `json:"passwordChangedAt,omitzero"`
+	HasOTP            bool            `json:"hasOtp,omitempty"`
+}
+
+// Custom field without its value
+type RedactedField struct {
+	Name     string `json:"name"`
+	HasValue bool   `json:"hasValue"`
+}
+
+// Name used to reveal the password of a Credential, custom fields are revealed by their own name
+const PasswordField string = "password"
+
+// Returns a copy of the Credential with the password and custom field values removed
+func (cred *Credential) Redact() RedactedCredential {
+	fields := make([]RedactedField, 0, len(cred.Fields))
+	for _, field := range cred.Fields {
+		fields = append(fields, RedactedField{Name: field.Name, HasValue: field.Value != ""})
+	}
+	return RedactedCredential{
+		ID:                cred.ID,
+		Title:             cred.Title,
+		URL:               cred.URL,
+		Username:          cred.Username,
+		HasPassword:       cred.Password != "",
+		Notes:             cred.Notes,
+		Tags:              cred.Tags,
+		Fields:            fields,
+		RotateBy:          cred.RotateBy,
+		RotationDays:      cred.RotationDays,
+		RequireReprompt:   cred.RequireReprompt,
+		PasswordChangedAt: cred.PasswordChangedAt,
+		HasOTP:            cred.OTP != "",
+	}
+}
+
+// Returns the secret value stored under field, either PasswordField or a custom field name
+func (cred *Credential) FieldValue(field string) (string, bool) {
+	if field == PasswordField {
+		return cred.Password, true
+	}
+	for _, custom := range cred.Fields {
+		if custom.Name == field {
+			return custom.Value, true
+		}
+	}
+	return "", false
+}
+
+// Generates a random hex ID for a new Credential
+func NewCredentialID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", fmt.Errorf("Could not generate a credential ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Sets RotateBy from the rotation interval, counting from the time the password was set.
+// Does nothing when the Credential has no rotation interval.
+func (cred *Credential) ScheduleRotation(passwordSetAt time.Time) {
+	if cred.RotationDays <= 0 {
+		return
+	}
+	cred.RotateBy = passwordSetAt.AddDate(0, 0, cred.RotationDays)
+}
+
+// Reports whether the password is past its rotate-by date
+func (cred *Credential) IsExpired(now time.Time) bool {
+	return !cred.RotateBy.IsZero() && !now.Before(cred.RotateBy)
+}
+
+// Reports whether the password is expired or has to be rotated within the given number of days
+func (cred *Credential) IsDueWithin(now time.Time, days int) bool {
+	if cred.RotateBy.IsZero() {
+		return false
+	}
+	return !now.AddDate(0, 0, days).Before(cred.RotateBy)
+}
+
+// Days until the rotate-by date, counted like IsDueWithin: the least number of days for which
+// the password is due, so one due in two and a half days has 3 left. Negative once expired
+// by a day or more. Zero when there is no rotate-by date.
+func (cred *Credential) DaysLeft(now time.Time) int {
+	if cred.RotateBy.IsZero() {
+		return 0
+	}
+	//Start from the whole days in between, then step over days of 23 or 25 hours
+	days := int(math.Floor(cred.RotateBy.Sub(now).Hours() / 24))
+	for !cred.IsDueWithin(now, days) {
+		days++
+	}
+	for cred.IsDueWithin(now, days-1) {
+		days--
+	}
+	return days
 }
 
 // Vault struct
@@ -36,13 +186,47 @@ func ReadVault(filePath string) ([]byte, error) {
 	return cipherText, nil
 }
 
-func getVault() (string, error) {
+// Name of the vault file of username. Anything but lower case letters, digits, '.', '_' and
+// '-' is escaped as %XX, so names stay distinct on case-insensitive file systems and never
+// reach outside the vaults directory.
+func vaultFileName(username string) string {
+	var name strings.Builder
+	for _, b := range []byte(username) {
+		if b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || b == '.' || b == '_' || b == '-' {
+			name.WriteByte(b)
+		} else {
+			fmt.Fprintf(&name, "%%%02X", b)
+		}
+	}
+	return name.String() + ".vault"
+}
 
+func vaultPath(username string) (string, error) {
 	appDir, err := GetAppConfigDir()
 	if err != nil {
 		return "", fmt.Errorf("Could not get the App directory. %w", err)
 	}
-	filePath := path.Join(appDir, vaultName)
+	vaultsDir := path.Join(appDir, vaultsDirName)
+	if err := os.MkdirAll(vaultsDir, 0700); err != nil {
+		return "", fmt.Errorf("Could not create the vaults directory. %w", err)
+	}
+	return path.Join(vaultsDir, vaultFileName(username)), nil
+}
+
+func legacyVaultPath() (string, error) {
+	appDir, err := GetAppConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("Could not get the App directory. %w", err)
+	}
+	return path.Join(appDir, legacyVaultName), nil
+}
+
+func getVault(username string) (string, error) {
+
+	filePath, err := vaultPath(username)
+	if err != nil {
+		return "", err
+	}
 
 	if exist, _ := vaultExist(filePath); exist != true {
 		CreateVault(filePath)
@@ -50,8 +234,8 @@ func getVault() (string, error) {
 	return filePath, nil
 }
 
-func WriteVault(cipherText []byte) error {
-	filePath, err := getVault()
+func WriteVault(username string, cipherText []byte) error {
+	filePath, err := getVault(username)
 	if err != nil {
 		return fmt.Errorf("Could not get Vault. %w.", err)
 	}
@@ -92,23 +276,98 @@ func GetAppConfigDir() (string, error) {
 	return appDir, nil
 }
 
-func LoadAndDecryptVault(MEK []byte) ([]Credential, error) {
-	//Get the Vault
-	vaultPath, err := getVault()
+// Reads the vault file of username and splits it into its header and the sealed credentials.
+// The header is nil for legacy vaults whose credentials are sealed directly under the
+// password-derived key. A user without a vault file of their own gets the shared vault of
+// older versions, see ClaimLegacyVault.
+func LoadVault(username string) (*Header, []byte, error) {
+	//Get the Vault, without creating it: a missing vault must not look like an empty one
+	filePath, err := vaultPath(username)
 	if err != nil {
-		return nil, fmt.Errorf("Loading Vault failed. %w", err)
+		return nil, nil, fmt.Errorf("Loading Vault failed. %w", err)
+	}
+	if !hasContents(filePath) {
+		if filePath, err = legacyVaultPath(); err != nil {
+			return nil, nil, fmt.Errorf("Loading Vault failed. %w", err)
+		}
+		if !hasContents(filePath) {
+			return nil, nil, ErrVaultMissing
+		}
 	}
 	//Read Vault
-	cipherText, err := ReadVault(vaultPath)
+	contents, err := ReadVault(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("Loading Vault Failed. %w", err)
+		return nil, nil, fmt.Errorf("Loading Vault Failed. %w", err)
+	}
+	header, sealed, err := parseVaultFile(contents)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Loading Vault Failed. %w: %v", ErrVaultCorrupt, err)
+	}
+	return header, sealed, nil
+}
+
+// Makes the shared vault of older versions the vault of username, once username has opened it.
+// Does nothing when username already has a vault file of their own.
+func ClaimLegacyVault(username string) error {
+	filePath, err := vaultPath(username)
+	if err != nil {
+		return err
+	}
+	legacyPath, err := legacyVaultPath()
+	if err != nil {
+		return err
+	}
+	if hasContents(filePath) || !hasContents(legacyPath) {
+		return nil
+	}
+	if err := os.Rename(legacyPath, filePath); err != nil {
+		return fmt.Errorf("Could not move the vault to %q. %w", filePath, err)
+	}
+	syncDir(path.Dir(filePath))
+	syncDir(path.Dir(legacyPath))
+	return nil
+}
+
+func hasContents(filePath string) bool {
+	info, err := os.Stat(filePath)
+	return err == nil && info.Size() > 0
+}
+
+// Decrypts sealed credentials as returned by LoadVault with the cipher suite and key its
+// header version call for
+func OpenVault(header *Header, sealed []byte, key []byte) ([]Credential, error) {
+	//A freshly created vault file is empty
+	if len(sealed) == 0 {
+		return []Credential{}, nil
+	}
+	//Legacy vaults have no header and are always AES256-GCM
+	suite := crypto.AES256GCM
+	if header != nil {
+		var err error
+		if suite, err = crypto.GetCipherSuite(header.Cipher); err != nil {
+			return nil, fmt.Errorf("Loading Vault failed. %w: %v", ErrVaultCorrupt, err)
+		}
+	}
+	if len(sealed) < suite.NonceSize() {
+		return nil, fmt.Errorf("Loading Vault failed. %w: vault file is truncated", ErrVaultCorrupt)
+	}
+
+	if header != nil && header.Version >= subkeyHeaderVersion {
+		subkey, err := crypto.DeriveSubkey(key, crypto.PURPOSE_VAULT_ENCRYPTION)
+		if err != nil {
+			return nil, fmt.Errorf("Loading Vault failed. %w", err)
+		}
+		defer crypto.Wipe(subkey)
+		key = subkey
 	}
 
 	//Decrypt it using key
-	decryptedData, err := crypto.Decrypt(MEK, cipherText)
+	plaintext, err := suite.DecryptSecret(key, sealed[:suite.NonceSize()], sealed[suite.NonceSize():])
 	if err != nil {
 		return nil, fmt.Errorf("Loading Vault failed. %w", err)
 	}
+	defer plaintext.Destroy()
+	decryptedData := plaintext.Bytes()
 
 	if len(decryptedData) == 0 {
 		return []Credential{}, nil
@@ -117,36 +376,68 @@ func LoadAndDecryptVault(MEK []byte) ([]Credential, error) {
 	var credentials []Credential
 	err = json.Unmarshal(decryptedData, &credentials)
 	if err != nil {
-		return nil, fmt.Errorf("Loading Vault Failed.error marshalling %w", err)
+		return nil, fmt.Errorf("Loading Vault Failed.error marshalling %w: %v", ErrVaultCorrupt, err)
 	}
 
 	return credentials, nil
 }
 
-func EncryptAndSaveVault(credentials []Credential, MEK []byte) error {
+// Encrypts credentials under the vault key and writes them with header to the vault of
+// username. A nil header writes the legacy format where key is the password-derived key.
+func EncryptAndSaveVault(username string, credentials []Credential, header *Header, key []byte) error {
+	contents, err := SealVault(credentials, header, key)
+	if err != nil {
+		return fmt.Errorf("Could not Encrypt and Save the credentials %w:", err)
+	}
+
+	//Write to Vault File
+	err = WriteVault(username, contents)
+
+	if err != nil {
+		return fmt.Errorf("Could not Encrypt and Save the credentials %w:", err)
+	}
+	return nil
+}
+
+// Encrypts the credentials into the bytes of a vault file without writing it, so the caller
+// can commit it together with other files
+func SealVault(credentials []Credential, header *Header, key []byte) ([]byte, error) {
 	//Marshall the Credentials into json
 	jsonData, err := json.Marshal(credentials)
 	if err != nil {
-		return fmt.Errorf("Could not Encrypt and Save the credentials %w:", err)
+		return nil, fmt.Errorf("Could not marshal the credentials %w:", err)
+	}
+	//Legacy vaults stay AES256-GCM under key, others are resealed with the default suite under
+	//the vault-encryption subkey
+	suite := crypto.AES256GCM
+	if header != nil {
+		suite = crypto.DefaultCipherSuite
+		header = header.Clone()
+		header.Cipher = suite.Name()
+		header.Version = headerVersion
+		subkey, err := crypto.DeriveSubkey(key, crypto.PURPOSE_VAULT_ENCRYPTION)
+		if err != nil {
+			return nil, fmt.Errorf("Could not Encrypt the credentials %w:", err)
+		}
+		defer crypto.Wipe(subkey)
+		key = subkey
 	}
-	//Encrypt the jsonData using the MasterEncryptionKey(MEK) and you recieve
+	//Encrypt the jsonData using the vault key and you recieve
 	//{
 	// nonce		Intialization Vector in []byte
 	// cipherText	EncryptedData in []byte
 	// err			if any
 	//}
-	nonce, cipherText, err := crypto.Encrypt(MEK, jsonData)
+	nonce, cipherText, err := suite.Encrypt(key, jsonData)
+	crypto.Wipe(jsonData)
 	if err != nil {
-		return fmt.Errorf("Could not Encrypt and Save the credentials %w:", err)
+		return nil, fmt.Errorf("Could not Encrypt the credentials %w:", err)
 	}
 	//combine nonce and ciphertext
-	nonce = append(nonce, cipherText...)
-
-	//Write to Vault File
-	err = WriteVault(nonce)
+	return formatVaultFile(header, append(nonce, cipherText...))
+}
 
-	if err != nil {
-		return fmt.Errorf("Could not Encrypt and Save the credentials %w:", err)
-	}
-	return nil
+// Path of the vault file of username, creating an empty one when missing
+func GetVaultPath(username string) (string, error) {
+	return getVault(username)
 }
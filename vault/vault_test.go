@@ -1,9 +1,14 @@
 package vault
 
 import (
+	"PasswordManager/crypto"
 	"crypto/rand"
+	"encoding/json"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestEncryptAndSaveVault(t *testing.T) {
@@ -15,10 +20,312 @@ func TestEncryptAndSaveVault(t *testing.T) {
 			t.Fatalf("Could not generate a MEK %v", err.Error())
 		}
 
-		err := EncryptAndSaveVault(nil, MEK)
+		err := EncryptAndSaveVault("alice", nil, nil, MEK)
 		if err != nil {
 			t.Errorf("Test Failed. %v", err.Error())
 		}
 
 	})
 }
+
+func TestCredentialRotation(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("No Policy", func(t *testing.T) {
+		cred := Credential{}
+		cred.ScheduleRotation(now)
+		if !cred.RotateBy.IsZero() {
+			t.Errorf("RotateBy should stay unset without a rotation interval, got %v", cred.RotateBy)
+		}
+		if cred.IsExpired(now) || cred.IsDueWithin(now, 3650) {
+			t.Error("Credential without a rotate-by date should never be due")
+		}
+	})
+
+	t.Run("Due Window", func(t *testing.T) {
+		cred := Credential{RotationDays: 90}
+		cred.ScheduleRotation(now)
+		want := now.AddDate(0, 0, 90)
+		if !cred.RotateBy.Equal(want) {
+			t.Fatalf("RotateBy mismatch. Got %v, want %v", cred.RotateBy, want)
+		}
+		if cred.IsDueWithin(now, 89) {
+			t.Error("Credential should not be due within 89 days")
+		}
+		if !cred.IsDueWithin(now, 90) {
+			t.Error("Credential should be due within 90 days")
+		}
+		if cred.IsExpired(now) {
+			t.Error("Credential should not be expired yet")
+		}
+		if !cred.IsExpired(want) {
+			t.Error("Credential should be expired on its rotate-by date")
+		}
+	})
+
+	t.Run("Days Left", func(t *testing.T) {
+		for _, test := range []struct {
+			rotateBy time.Time
+			want     int
+		}{
+			{now.AddDate(0, 0, 2), 2},
+			{now.AddDate(0, 0, 2).Add(time.Minute), 3},
+			{now.AddDate(0, 0, 2).Add(-time.Minute), 2},
+			{now.Add(time.Minute), 1},
+			{now, 0},
+			{now.Add(-time.Minute), 0},
+			{now.AddDate(0, 0, -1), -1},
+			{now.AddDate(0, 0, -1).Add(-time.Minute), -1},
+		} {
+			cred := Credential{RotateBy: test.rotateBy}
+			got := cred.DaysLeft(now)
+			if got != test.want {
+				t.Errorf("DaysLeft for %v = %d, want %d", test.rotateBy, got, test.want)
+			}
+			if !cred.IsDueWithin(now, got) || cred.IsDueWithin(now, got-1) {
+				t.Errorf("DaysLeft for %v = %d disagrees with IsDueWithin", test.rotateBy, got)
+			}
+		}
+	})
+}
+
+func TestCredentialRedact(t *testing.T) {
+	cred := Credential{
+		ID:       "abc",
+		URL:      "https://example.com",
+		Password: "hunter2",
+		Fields:   []CustomField{{Name: "PIN", Value: "1234"}, {Name: "Empty"}},
+	}
+
+	redacted := cred.Redact()
+	if !redacted.HasPassword {
+		t.Error("HasPassword should be set when the credential has a password")
+	}
+	if len(redacted.Fields) != 2 || !redacted.Fields[0].HasValue || redacted.Fields[1].HasValue {
+		t.Errorf("Custom fields not redacted as expected: %+v", redacted.Fields)
+	}
+
+	if value, ok := cred.FieldValue(PasswordField); !ok || value != "hunter2" {
+		t.Errorf("FieldValue(password) = %q, %v", value, ok)
+	}
+	if value, ok := cred.FieldValue("PIN"); !ok || value != "1234" {
+		t.Errorf("FieldValue(PIN) = %q, %v", value, ok)
+	}
+	if _, ok := cred.FieldValue("missing"); ok {
+		t.Error("FieldValue should report unknown fields")
+	}
+}
+
+func TestCommitFiles(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+	appDir, err := GetAppConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := filepath.Join(appDir, "first")
+	second := filepath.Join(appDir, "second")
+
+	t.Run("Commit", func(t *testing.T) {
+		err := CommitFiles(map[string][]byte{first: []byte("one"), second: []byte("two")})
+		if err != nil {
+			t.Fatalf("CommitFiles failed: %v", err)
+		}
+		assertFile(t, first, "one")
+		assertFile(t, second, "two")
+	})
+
+	t.Run("Recover Interrupted Commit", func(t *testing.T) {
+		//Staged files and marker written, process died before the renames
+		os.WriteFile(first+pendingSuffix, []byte("uno"), 0600)
+		os.WriteFile(second+pendingSuffix, []byte("dos"), 0600)
+		marker, _ := json.Marshal([]string{first, second})
+		os.WriteFile(filepath.Join(appDir, commitMarkerName), marker, 0600)
+
+		if err := RecoverPendingCommit(); err != nil {
+			t.Fatalf("RecoverPendingCommit failed: %v", err)
+		}
+		assertFile(t, first, "uno")
+		assertFile(t, second, "dos")
+		if _, err := os.Stat(filepath.Join(appDir, commitMarkerName)); !os.IsNotExist(err) {
+			t.Error("Commit marker should be removed after recovery")
+		}
+	})
+
+	t.Run("Ignore Unmarked Staged Files", func(t *testing.T) {
+		//Process died while staging, before the marker was written
+		os.WriteFile(first+pendingSuffix, []byte("partial"), 0600)
+
+		if err := RecoverPendingCommit(); err != nil {
+			t.Fatalf("RecoverPendingCommit failed: %v", err)
+		}
+		assertFile(t, first, "uno")
+	})
+
+	t.Run("Recover Crash After Marker", func(t *testing.T) {
+		//Process died right after the marker was written
+		if _, _, err := stageCommit(map[string][]byte{first: []byte("eins"), second: []byte("zwei")}); err != nil {
+			t.Fatalf("stageCommit failed: %v", err)
+		}
+		assertFile(t, first, "uno")
+		assertFile(t, second, "dos")
+
+		if err := RecoverPendingCommit(); err != nil {
+			t.Fatalf("RecoverPendingCommit failed: %v", err)
+		}
+		assertFile(t, first, "eins")
+		assertFile(t, second, "zwei")
+		for _, leftover := range []string{first + pendingSuffix, second + pendingSuffix, filepath.Join(appDir, commitMarkerName)} {
+			if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+				t.Errorf("%q should be gone after recovery", leftover)
+			}
+		}
+	})
+}
+
+func assertFile(t *testing.T, filePath string, want string) {
+	t.Helper()
+	got, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Could not read %q: %v", filePath, err)
+	}
+	if string(got) != want {
+		t.Errorf("File %q mismatch. Got %q, want %q", filePath, got, want)
+	}
+}
+
+func TestVaultHeader(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	vaultKey := make([]byte, 32)
+	passwordKey := make([]byte, 32)
+	otherKey := make([]byte, 32)
+	for _, key := range [][]byte{vaultKey, passwordKey, otherKey} {
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	header := NewHeader()
+	if err := header.SetKeySlot(SlotPassword, vaultKey, passwordKey); err != nil {
+		t.Fatalf("SetKeySlot failed: %v", err)
+	}
+	credentials := []Credential{{ID: "1", Password: "secret"}}
+	if err := EncryptAndSaveVault("alice", credentials, header, vaultKey); err != nil {
+		t.Fatalf("EncryptAndSaveVault failed: %v", err)
+	}
+
+	loadedHeader, sealed, err := LoadVault("alice")
+	if err != nil || loadedHeader == nil {
+		t.Fatalf("LoadVault failed: %v", err)
+	}
+	if _, err := loadedHeader.UnwrapKey(SlotPassword, otherKey); err == nil {
+		t.Error("Unwrapping with the wrong key should fail")
+	}
+	unwrapped, err := loadedHeader.UnwrapKey(SlotPassword, passwordKey)
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	loaded, err := OpenVault(loadedHeader, sealed, unwrapped)
+	if err != nil || len(loaded) != 1 || loaded[0].Password != "secret" {
+		t.Fatalf("OpenVault returned %+v, %v", loaded, err)
+	}
+	if loadedHeader.Cipher != crypto.DefaultCipherSuite.Name() || loadedHeader.KeySlots[0].Cipher != crypto.DefaultCipherSuite.Name() {
+		t.Errorf("New vaults should record the default cipher suite, got %q and %q", loadedHeader.Cipher, loadedHeader.KeySlots[0].Cipher)
+	}
+	if loadedHeader.Version != subkeyHeaderVersion {
+		t.Errorf("New vaults should be sealed under the vault-encryption subkey, got version %d", loadedHeader.Version)
+	}
+	if _, err := OpenVault(&Header{Version: oldestHeaderVersion, Cipher: loadedHeader.Cipher}, sealed, unwrapped); err == nil {
+		t.Error("Credentials sealed under the subkey must not open with the vault key itself")
+	}
+
+	//Headers written before suites were recorded are AES256-GCM throughout
+	slotNonce, wrapped, err := crypto.Encrypt(passwordKey, vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, cipherText, err := crypto.Encrypt(vaultKey, []byte(`[{"id":"1","password":"secret"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldHeader := &Header{Version: oldestHeaderVersion, KeySlots: []KeySlot{{Kind: SlotPassword, Nonce: slotNonce, WrappedKey: wrapped}}}
+	oldKey, err := oldHeader.UnwrapKey(SlotPassword, passwordKey)
+	if err != nil {
+		t.Fatalf("UnwrapKey failed on an AES256-GCM slot: %v", err)
+	}
+	loaded, err = OpenVault(oldHeader, append(nonce, cipherText...), oldKey)
+	if err != nil || len(loaded) != 1 || loaded[0].Password != "secret" {
+		t.Fatalf("OpenVault returned %+v, %v for an AES256-GCM vault", loaded, err)
+	}
+
+	//Re-wrapping one slot must not disturb the vault key
+	if err := loadedHeader.SetKeySlot(SlotPassword, unwrapped, otherKey); err != nil {
+		t.Fatal(err)
+	}
+	if len(loadedHeader.KeySlots) != 1 {
+		t.Errorf("SetKeySlot should replace the existing slot, got %d slots", len(loadedHeader.KeySlots))
+	}
+	rewrapped, err := loadedHeader.UnwrapKey(SlotPassword, otherKey)
+	if err != nil || string(rewrapped) != string(vaultKey) {
+		t.Errorf("Re-wrapped slot returned a different vault key, %v", err)
+	}
+}
+
+func TestVaultPerUser(t *testing.T) {
+	t.Setenv("AppData", t.TempDir())
+
+	for username, name := range map[string]string{"alice": "alice.vault", "Alice": "%41lice.vault", "../bob": "..%2Fbob.vault"} {
+		if got := vaultFileName(username); got != name {
+			t.Errorf("vaultFileName(%q) = %q, want %q", username, got, name)
+		}
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptAndSaveVault("alice", []Credential{{ID: "1"}}, nil, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncryptAndSaveVault("bob", []Credential{{ID: "2"}, {ID: "3"}}, nil, key); err != nil {
+		t.Fatal(err)
+	}
+	for username, count := range map[string]int{"alice": 1, "bob": 2} {
+		header, sealed, err := LoadVault(username)
+		if err != nil {
+			t.Fatalf("LoadVault(%q) failed: %v", username, err)
+		}
+		if loaded, err := OpenVault(header, sealed, key); err != nil || len(loaded) != count {
+			t.Errorf("Vault of %q returned %+v, %v", username, loaded, err)
+		}
+	}
+
+	//The shared vault of older versions is read until someone claims it
+	appDir, err := GetAppConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := SealVault([]Credential{{ID: "legacy"}}, nil, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(appDir, legacyVaultName), legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadVault("carol"); err != nil {
+		t.Fatalf("LoadVault should fall back to the legacy vault, got %v", err)
+	}
+	if err := ClaimLegacyVault("carol"); err != nil {
+		t.Fatalf("ClaimLegacyVault failed: %v", err)
+	}
+	header, sealed, err := LoadVault("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := OpenVault(header, sealed, key); err != nil || len(loaded) != 1 || loaded[0].ID != "legacy" {
+		t.Errorf("Claimed vault returned %+v, %v", loaded, err)
+	}
+	if _, _, err := LoadVault("dave"); err != ErrVaultMissing {
+		t.Errorf("A claimed legacy vault must not be handed to anyone else, got %v", err)
+	}
+}
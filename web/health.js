@@ -0,0 +1,90 @@
+// web/health.js
+
+document.addEventListener('DOMContentLoaded', () => {
+	const scoreSpan = document.getElementById('score');
+	const itemsTableBody = document.querySelector('#itemsTable tbody');
+	const noFindingsMessage = document.getElementById('noFindingsMessage');
+	const breachNote = document.getElementById('breachNote');
+
+	const findingLabels = {
+		breached: 'Breached password',
+		weak: 'Weak password',
+		reused: 'Reused password',
+		old: 'Old password',
+		'insecure-url': 'No HTTPS',
+		'missing-2fa': 'Missing 2FA',
+	};
+
+	/**
+	 * Colour class for a score from 0 to 100.
+	 * @param {number} score
+	 */
+	function scoreClass(score) {
+		if (score >= 80) {
+			return 'good';
+		}
+		return score >= 50 ? 'fair' : 'poor';
+	}
+
+	/**
+	 * Fetches the health report and fills in the summary and the item table.
+	 */
+	async function loadReport() {
+		const response = await fetch('/api/audit');
+		if (!response.ok) {
+			window.location.href = 'index.html'; // Signed out or vault locked
+			return;
+		}
+		const report = await response.json();
+
+		scoreSpan.textContent = report.score;
+		scoreSpan.className = `value ${scoreClass(report.score)}`;
+		breachNote.style.display = report.breachChecked ? 'none' : 'block';
+		for (const kind of Object.keys(findingLabels)) {
+			document.getElementById(`count-${kind}`).textContent =
+				report.counts[kind] || 0;
+		}
+
+		//Titles of reused items, to name them next to each other
+		const titles = {};
+		report.items.forEach((item) => {
+			titles[item.id] = item.title || item.url;
+		});
+
+		itemsTableBody.innerHTML = '';
+		noFindingsMessage.style.display =
+			report.items.length === 0 ? 'block' : 'none';
+		report.items.forEach((item) => {
+			const row = itemsTableBody.insertRow();
+			const name = row.insertCell();
+			name.textContent = item.title || item.url;
+			if (item.title && item.url) {
+				name.appendChild(document.createElement('br'));
+				name.appendChild(document.createTextNode(item.url));
+			}
+
+			const score = row.insertCell();
+			score.textContent = item.score;
+			score.className = scoreClass(item.score);
+
+			const list = document.createElement('ul');
+			item.findings.forEach((finding) => {
+				const entry = document.createElement('li');
+				let text = findingLabels[finding.kind] || finding.kind;
+				if (finding.detail) {
+					text += `: ${finding.detail}`;
+				}
+				if (finding.reusedWith) {
+					text += ` (${finding.reusedWith.map((id) => titles[id] || id).join(', ')})`;
+				}
+				entry.textContent = text;
+				list.appendChild(entry);
+			});
+			row.insertCell().appendChild(list);
+		});
+	}
+
+	loadReport().catch((error) => {
+		console.error('Error loading vault health:', error);
+	});
+});
@@ -2,6 +2,7 @@
 
 document.addEventListener('DOMContentLoaded', () => {
 	const logoutBtn = document.getElementById('logoutBtn');
+	const exportBtn = document.getElementById('exportBtn');
 	const credentialsTableBody = document.querySelector(
 		'#credentialsTable tbody',
 	);
@@ -9,7 +10,17 @@ document.addEventListener('DOMContentLoaded', () => {
 		'noCredentialsMessage',
 	);
 	const addCredentialForm = document.getElementById('addCredentialForm');
+	const changePasswordForm = document.getElementById('changePasswordForm');
+	const recoverySharesForm = document.getElementById('recoverySharesForm');
+	const recoverySharesList = document.getElementById('recoverySharesList');
+	const generatePasswordBtn = document.getElementById('generatePasswordBtn');
+	const twoFactorEnrollForm = document.getElementById('twoFactorEnrollForm');
+	const twoFactorOnForm = document.getElementById('twoFactorOnForm');
+	const backupCodesList = document.getElementById('backupCodesList');
 	const messageDiv = document.getElementById('message');
+	const expiringMessageDiv = document.getElementById('expiringMessage');
+	const searchInput = document.getElementById('searchInput');
+	let searchTimer = null;
 
 	// --- Helper Functions ---
 
@@ -52,12 +63,159 @@ document.addEventListener('DOMContentLoaded', () => {
 		}
 	}
 
+	/**
+	 * Asks the backend for one secret field of an item. When the server wants the master
+	 * password re-entered, prompts for it and retries once.
+	 * @param {string} id - The item ID.
+	 * @param {string} field - 'password' or a custom field name.
+	 * @param {string} purpose - 'reveal' or 'copy'.
+	 * @returns {Promise<string>} The revealed value.
+	 */
+	async function revealField(id, field, purpose = 'reveal') {
+		let body = { id, field, purpose };
+		for (let attempt = 0; attempt < 2; attempt++) {
+			const response = await fetch('/api/credentials/reveal', {
+				method: 'POST',
+				headers: {
+					'Content-Type': 'application/json',
+				},
+				body: JSON.stringify(body),
+			});
+			const data = await response.json();
+			if (response.ok) {
+				return data.value;
+			}
+			if (!data.repromptRequired || attempt > 0) {
+				throw new Error(data.message || 'Failed to reveal field');
+			}
+			const masterPassword = window.prompt('Re-enter your master password:');
+			if (!masterPassword) {
+				throw new Error('Master password required');
+			}
+			body = { id, field, purpose, masterPassword };
+		}
+	}
+
+	/**
+	 * Renders a masked password with a button that fetches the real value on demand.
+	 */
+	function renderPasswordCell(cell, cred) {
+		if (!cred.hasPassword) {
+			return;
+		}
+		const value = document.createElement('span');
+		value.textContent = '••••••••';
+		const toggle = document.createElement('button');
+		toggle.type = 'button';
+		toggle.textContent = 'Show';
+		toggle.addEventListener('click', async () => {
+			if (toggle.textContent === 'Hide') {
+				value.textContent = '••••••••';
+				toggle.textContent = 'Show';
+				return;
+			}
+			try {
+				value.textContent = await revealField(cred.id, 'password');
+				toggle.textContent = 'Hide';
+			} catch (error) {
+				showMessage(`Error revealing password: ${error.message}`, 'error');
+			}
+		});
+		const copy = document.createElement('button');
+		copy.type = 'button';
+		copy.textContent = 'Copy';
+		copy.addEventListener('click', async () => {
+			try {
+				await navigator.clipboard.writeText(
+					await revealField(cred.id, 'password', 'copy'),
+				);
+				showMessage('Password copied to clipboard.', 'success');
+			} catch (error) {
+				showMessage(`Error copying password: ${error.message}`, 'error');
+			}
+		});
+		cell.append(value, ' ', toggle, ' ', copy);
+	}
+
+	/**
+	 * Asks the backend for the current one-time code of an item, re-prompting for the master
+	 * password once when the server asks for it.
+	 * @param {string} id - The item ID.
+	 * @returns {Promise<object>} The code, and for TOTP the seconds it stays valid.
+	 */
+	async function fetchOTPCode(id) {
+		let body = { id };
+		for (let attempt = 0; attempt < 2; attempt++) {
+			const response = await fetch('/api/credentials/otp', {
+				method: 'POST',
+				headers: {
+					'Content-Type': 'application/json',
+				},
+				body: JSON.stringify(body),
+			});
+			const data = await response.json();
+			if (response.ok) {
+				return data;
+			}
+			if (!data.repromptRequired || attempt > 0) {
+				throw new Error(data.message || 'Failed to get code');
+			}
+			const masterPassword = window.prompt('Re-enter your master password:');
+			if (!masterPassword) {
+				throw new Error('Master password required');
+			}
+			body = { id, masterPassword };
+		}
+	}
+
+	/**
+	 * Renders a button that shows the item's current one-time code. TOTP codes count down
+	 * and disappear once they expire.
+	 */
+	function renderOTPCell(cell, cred) {
+		if (!cred.hasOtp) {
+			return;
+		}
+		const value = document.createElement('span');
+		const button = document.createElement('button');
+		button.type = 'button';
+		button.textContent = 'Code';
+		let timer = null;
+		button.addEventListener('click', async () => {
+			try {
+				const data = await fetchOTPCode(cred.id);
+				clearInterval(timer);
+				if (data.type !== 'totp') {
+					value.textContent = data.code;
+					return;
+				}
+				let remaining = data.remaining;
+				value.textContent = `${data.code} (${remaining}s)`;
+				timer = setInterval(() => {
+					remaining--;
+					if (remaining <= 0) {
+						clearInterval(timer);
+						value.textContent = '';
+						return;
+					}
+					value.textContent = `${data.code} (${remaining}s)`;
+				}, 1000);
+			} catch (error) {
+				showMessage(`Error getting code: ${error.message}`, 'error');
+			}
+		});
+		cell.append(value, ' ', button);
+	}
+
 	/**
 	 * Fetches and renders credentials from the backend.
 	 */
 	async function fetchAndRenderCredentials() {
 		try {
-			const response = await fetch('/api/credentials');
+			const query = searchInput.value.trim();
+			const response = await fetch(
+				`/api/credentials?q=${encodeURIComponent(query)}`,
+			);
 			const data = await response.json();
 
 			if (!response.ok) {
@@ -74,10 +232,15 @@ document.addEventListener('DOMContentLoaded', () => {
 				credentialsTableBody.style.display = 'table-row-group'; // Ensure tbody is visible
 				data.forEach((cred) => {
 					const row = credentialsTableBody.insertRow();
-					row.insertCell(0).textContent = cred.url;
-					row.insertCell(1).textContent = cred.username;
-					row.insertCell(2).textContent = cred.password; // This will be masked from backend
-					row.insertCell(3).textContent = cred.notes || '';
+					row.insertCell(0).textContent = cred.title || '';
+					row.insertCell(1).textContent = cred.url;
+					row.insertCell(2).textContent = cred.username;
+					renderPasswordCell(row.insertCell(3), cred);
+					renderOTPCell(row.insertCell(4), cred);
+					row.insertCell(5).textContent = cred.notes || '';
+					row.insertCell(6).textContent = cred.rotateBy
+						? new Date(cred.rotateBy).toLocaleDateString()
+						: '';
 				});
 			}
 		} catch (error) {
@@ -86,11 +249,124 @@ document.addEventListener('DOMContentLoaded', () => {
 		}
 	}
 
+	/**
+	 * Fetches items that are expired or due for rotation and lists them in a banner.
+	 */
+	async function fetchExpiringCredentials() {
+		try {
+			const response = await fetch('/api/credentials/expiring?days=14');
+			if (!response.ok) {
+				return;
+			}
+			const data = await response.json();
+			if (data.length === 0) {
+				expiringMessageDiv.style.display = 'none';
+				return;
+			}
+			const names = data.map((cred) =>
+				cred.expired ? `${cred.url} (expired)` : `${cred.url} (${cred.daysLeft} days left)`,
+			);
+			expiringMessageDiv.textContent = `Passwords due for rotation: ${names.join(', ')}`;
+			expiringMessageDiv.style.display = 'block';
+		} catch (error) {
+			console.error('Error fetching expiring credentials:', error);
+		}
+	}
+
+	/**
+	 * Shows whether two-factor sign-in is on and the controls that fit.
+	 */
+	async function fetchTwoFactorStatus() {
+		try {
+			const response = await fetch('/api/two-factor');
+			if (!response.ok) {
+				return;
+			}
+			const data = await response.json();
+			document.getElementById('twoFactorStatus').textContent = data.enabled
+				? `Signing in needs a code from your authenticator app. ${data.backupCodesLeft} backup codes left.`
+				: 'Signing in only needs your master password.';
+			document.getElementById('twoFactorOff').style.display = data.enabled ? 'none' : 'block';
+			twoFactorOnForm.style.display = data.enabled ? 'block' : 'none';
+		} catch (error) {
+			console.error('Error fetching two-factor status:', error);
+		}
+	}
+
+	/**
+	 * Posts to a two-factor endpoint and lists any backup codes in the reply.
+	 * @param {string} url - The endpoint.
+	 * @param {object} body - The request body.
+	 */
+	async function postTwoFactor(url, body) {
+		const response = await fetch(url, {
+			method: 'POST',
+			headers: {
+				'Content-Type': 'application/json',
+			},
+			body: JSON.stringify(body),
+		});
+		const data = await response.json();
+		if (!response.ok) {
+			throw new Error(data.message || 'Request failed');
+		}
+		// Backup codes are shown once and only stored hashed
+		backupCodesList.innerHTML = '';
+		for (const code of data.backupCodes || []) {
+			const item = document.createElement('li');
+			item.textContent = code;
+			backupCodesList.appendChild(item);
+		}
+		showMessage(data.message, 'success');
+		fetchTwoFactorStatus();
+	}
+
 	// --- Event Listeners ---
 
 	// Check login status on page load
 	checkLoginStatus();
 	fetchAndRenderCredentials(); // Fetch credentials if logged in
+	fetchExpiringCredentials();
+	fetchTwoFactorStatus();
+
+	// Search box handler, debounced so we don't query on every keystroke
+	searchInput.addEventListener('input', () => {
+		clearTimeout(searchTimer);
+		searchTimer = setTimeout(fetchAndRenderCredentials, 250);
+	});
+
+	// Export button handler, re-prompts for the master password when the server asks for it
+	exportBtn.addEventListener('click', async () => {
+		try {
+			let response = await fetch('/api/export', { method: 'POST' });
+			if (response.status === 403) {
+				const masterPassword = window.prompt('Re-enter your master password:');
+				if (!masterPassword) {
+					return;
+				}
+				response = await fetch('/api/export', {
+					method: 'POST',
+					headers: {
+						'Content-Type': 'application/json',
+					},
+					body: JSON.stringify({ masterPassword }),
+				});
+			}
+			if (!response.ok) {
+				const data = await response.json();
+				throw new Error(data.message || 'Export failed');
+			}
+			const blob = await response.blob();
+			const link = document.createElement('a');
+			link.href = URL.createObjectURL(blob);
+			link.download = 'vault-export.json';
+			link.click();
+			URL.revokeObjectURL(link.href);
+		} catch (error) {
+			console.error('Error exporting vault:', error);
+			showMessage(`Export error: ${error.message}`, 'error');
+		}
+	});
 
 	// Logout button handler
 	logoutBtn.addEventListener('click', async () => {
@@ -116,14 +392,201 @@ document.addEventListener('DOMContentLoaded', () => {
 		}
 	});
 
+	// Change Master Password form handler
+	changePasswordForm.addEventListener('submit', async (event) => {
+		event.preventDefault();
+
+		const oldPassword = document.getElementById('oldMasterPassword').value;
+		const newPassword = document.getElementById('newMasterPassword').value;
+		const confirmPassword = document.getElementById(
+			'confirmMasterPassword',
+		).value;
+		if (newPassword !== confirmPassword) {
+			showMessage('New master passwords do not match.', 'error');
+			return;
+		}
+
+		try {
+			const response = await fetch('/api/change-password', {
+				method: 'POST',
+				headers: {
+					'Content-Type': 'application/json',
+				},
+				body: JSON.stringify({ oldPassword, newPassword }),
+			});
+			const data = await response.json();
+			if (!response.ok) {
+				throw new Error(data.message || 'Failed to change master password');
+			}
+			showMessage('Master password changed.', 'success');
+			changePasswordForm.reset();
+		} catch (error) {
+			console.error('Error changing master password:', error);
+			showMessage(`Error changing master password: ${error.message}`, 'error');
+		}
+	});
+
+	recoverySharesForm.addEventListener('submit', async (event) => {
+		event.preventDefault();
+
+		const total = Number(document.getElementById('sharesTotal').value);
+		const threshold = Number(document.getElementById('sharesThreshold').value);
+		const masterPassword = document.getElementById('sharesMasterPassword').value;
+		recoverySharesList.innerHTML = '';
+		try {
+			const response = await fetch('/api/recovery-shares', {
+				method: 'POST',
+				headers: {
+					'Content-Type': 'application/json',
+				},
+				body: JSON.stringify({ masterPassword, total, threshold }),
+			});
+			const data = await response.json();
+			if (!response.ok) {
+				throw new Error(data.message || 'Failed to create recovery shares');
+			}
+			// Shares are shown once and never stored, so they have to be handed out now
+			for (const share of data.shares) {
+				const item = document.createElement('li');
+				item.textContent = share;
+				recoverySharesList.appendChild(item);
+			}
+			showMessage(`${data.message}. Any ${threshold} of them recover the vault.`, 'success');
+			document.getElementById('sharesMasterPassword').value = '';
+		} catch (error) {
+			console.error('Error creating recovery shares:', error);
+			showMessage(`Error creating recovery shares: ${error.message}`, 'error');
+		}
+	});
+
+	// Starts enrolling an authenticator app and shows its key
+	document.getElementById('enableTwoFactorBtn').addEventListener('click', async () => {
+		try {
+			const response = await fetch('/api/two-factor/enroll', { method: 'POST' });
+			const data = await response.json();
+			if (!response.ok) {
+				throw new Error(data.message || 'Failed to start setup');
+			}
+			document.getElementById('twoFactorUri').textContent = data.uri;
+			document.getElementById('twoFactorSecret').textContent = data.secret;
+			twoFactorEnrollForm.style.display = 'block';
+		} catch (error) {
+			console.error('Error setting up two-factor sign-in:', error);
+			showMessage(`Error setting up two-factor sign-in: ${error.message}`, 'error');
+		}
+	});
+
+	twoFactorEnrollForm.addEventListener('submit', async (event) => {
+		event.preventDefault();
+		try {
+			await postTwoFactor('/api/two-factor/confirm', {
+				code: document.getElementById('twoFactorCode').value.trim(),
+			});
+			twoFactorEnrollForm.reset();
+			twoFactorEnrollForm.style.display = 'none';
+		} catch (error) {
+			showMessage(`Error turning on two-factor sign-in: ${error.message}`, 'error');
+		}
+	});
+
+	// Both buttons need the master password, the one clicked picks the endpoint
+	twoFactorOnForm.addEventListener('submit', async (event) => {
+		event.preventDefault();
+		const url =
+			event.submitter.id === 'disableTwoFactorBtn'
+				? '/api/two-factor/disable'
+				: '/api/two-factor/backup-codes';
+		try {
+			await postTwoFactor(url, {
+				masterPassword: document.getElementById('twoFactorMasterPassword').value,
+			});
+			twoFactorOnForm.reset();
+		} catch (error) {
+			showMessage(`Error: ${error.message}`, 'error');
+		}
+	});
+
+	// Fills the new password field from the generator with the chosen options
+	generatePasswordBtn.addEventListener('click', async () => {
+		const kind = document.getElementById('generateKind').value;
+		let url = '/api/generate';
+		let options;
+		if (kind === 'passphrase') {
+			url += '?type=passphrase';
+			options = {
+				words: Number(document.getElementById('generateWords').value),
+				separator: '-',
+				capitalize: document.getElementById('generateCapitalize').checked ? 'words' : '',
+				digits: document.getElementById('generateDigit').checked ? 1 : 0,
+			};
+		} else {
+			// Lets the server apply the password rules of the item's site
+			const itemUrl = document.getElementById('newUrl').value;
+			if (itemUrl) {
+				url += `?url=${encodeURIComponent(itemUrl)}`;
+			}
+			const symbols = document.getElementById('generateSymbols').checked;
+			options = {
+				length: Number(document.getElementById('generateLength').value),
+				lowercase: true,
+				uppercase: true,
+				digits: true,
+				symbols,
+				minLowercase: 1,
+				minUppercase: 1,
+				minDigits: 1,
+				minSymbols: symbols ? 1 : 0,
+				excludeAmbiguous: document.getElementById('generateNoAmbiguous').checked,
+			};
+		}
+		try {
+			const response = await fetch(url, {
+				method: 'POST',
+				headers: {
+					'Content-Type': 'application/json',
+				},
+				body: JSON.stringify(options),
+			});
+			const data = await response.json();
+			if (!response.ok) {
+				throw new Error(data.message || 'Failed to generate a password');
+			}
+			const passwordInput = document.getElementById('newPassword');
+			passwordInput.value = data.password;
+			passwordInput.type = 'text';
+			if (data.policy) {
+				showMessage(`Generated following the password rules of ${data.policy}.`, 'info');
+			} else if (data.entropy) {
+				showMessage(`Passphrase has about ${Math.floor(data.entropy)} bits of entropy.`, 'info');
+			}
+		} catch (error) {
+			console.error('Error generating password:', error);
+			showMessage(`Error generating password: ${error.message}`, 'error');
+		}
+	});
+
 	// Add Credential form handler
 	addCredentialForm.addEventListener('submit', async (event) => {
 		event.preventDefault(); // Prevent default form submission
 
+		const newTitle = document.getElementById('newTitle').value;
 		const newUrl = document.getElementById('newUrl').value;
 		const newUsername = document.getElementById('newUsername').value;
 		const newPassword = document.getElementById('newPassword').value;
 		const newNotes = document.getElementById('newNotes').value;
+		const newOtp = document.getElementById('newOtp').value.trim();
+		const newTags = document
+			.getElementById('newTags')
+			.value.split(',')
+			.map((tag) => tag.trim())
+			.filter((tag) => tag !== '');
+		const newRotationDays = parseInt(
+			document.getElementById('newRotationDays').value,
+			10,
+		);
+		const newRotateBy = document.getElementById('newRotateBy').value;
+		const newRequireReprompt =
+			document.getElementById('newRequireReprompt').checked;
 
 		try {
 			const response = await fetch('/api/add-credential', {
@@ -132,19 +595,34 @@ document.addEventListener('DOMContentLoaded', () => {
 					'Content-Type': 'application/json',
 				},
 				body: JSON.stringify({
+					title: newTitle,
 					url: newUrl,
 					username: newUsername,
 					password: newPassword,
 					notes: newNotes,
+					tags: newTags,
+					otp: newOtp,
+					rotationDays: Number.isNaN(newRotationDays) ? 0 : newRotationDays,
+					rotateBy: newRotateBy ? new Date(newRotateBy).toISOString() : undefined,
+					requireReprompt: newRequireReprompt,
 				}),
 			});
 			if (!response.ok) {
-				throw new Error(data.error || 'Failed to add credential');
+				const failure = await response.json().catch(() => ({}));
+				throw new Error(failure.message || 'Failed to add credential');
 			}
+			const data = await response.json();
 
-			showMessage('Credential added successfully!', 'success');
+			if (data.weak) {
+				const warning = data.strength.feedback.warning || 'This password is easy to guess';
+				showMessage(`Credential added, but: ${warning}. Consider generating a new password.`, 'error');
+			} else {
+				showMessage('Credential added successfully!', 'success');
+			}
 			addCredentialForm.reset(); // Clear the form
+			document.getElementById('newPassword').type = 'password';
 			fetchAndRenderCredentials(); // Refresh the list
+			fetchExpiringCredentials();
 		} catch (error) {
 			console.error('Error adding credential:', error);
 			showMessage(`Error adding credential: ${error.message}`, 'error');